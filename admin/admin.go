@@ -1,6 +1,7 @@
 package admin
 
 import (
+	"context"
 	_ "embed"
 	"encoding/json"
 	"fmt"
@@ -11,18 +12,34 @@ import (
 	"net/url"
 	"os"
 	"path/filepath"
+	"strconv"
 
+	"github.com/posener/flarm/flarmport"
 	"github.com/posener/googleauth"
 )
 
 type Config struct {
 	AllowedEmails []string
+	// IGCDir is the directory where flights that are downloaded from the device are stored. If
+	// empty, flights can't be downloaded from the admin page.
+	IGCDir string
+}
+
+// Device is a flarm device that its status and configuration can be viewed, its configuration
+// can be changed and its flights can be downloaded in the admin page.
+type Device interface {
+	DeviceStatus() (flarmport.DeviceStatus, error)
+	DeviceConfig(ctx context.Context) (flarmport.DeviceConfig, error)
+	SetConfig(ctx context.Context, key, value string) (string, error)
+	DownloadIGC(ctx context.Context, dir string) ([]string, error)
 }
 
 //go:embed admin.html.gotmpl
 var page []byte
 
-func New(cfg Config, path string, data interface{}, reset func()) (*Admin, error) {
+// New returns the admin page. The device is optional, and if it is nil the device
+// section is not shown.
+func New(cfg Config, path string, data interface{}, reset func(), device Device) (*Admin, error) {
 	tmpl, err := template.New("admin.html").Parse(string(page))
 	if err != nil {
 		return nil, err
@@ -52,6 +69,7 @@ func New(cfg Config, path string, data interface{}, reset func()) (*Admin, error
 		data:    string(jsonData),
 		path:    path,
 		reset:   reset,
+		device:  device,
 		allowed: allowed,
 		cfg:     cfg,
 	}, nil
@@ -62,17 +80,51 @@ type Admin struct {
 	data    string
 	path    string
 	reset   func()
+	device  Device
 	allowed map[string]bool
 	cfg     Config
 }
 
-func (a *Admin) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+// pageData is the data of the admin page template.
+type pageData struct {
+	Config string
+	// ShowDevice is true if the device section should be shown.
+	ShowDevice bool
+	Status     flarmport.DeviceStatus
+	Device     flarmport.DeviceConfig
+	DeviceErr  string
+	DeviceKeys []string
+	// ShowIGC is true if flights can be downloaded from the device.
+	ShowIGC bool
+}
+
+// Authorize returns a handler that allows only the admin users to access the given handler. It
+// should be wrapped with the authentication middleware.
+func (a *Admin) Authorize(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !a.authorized(w, r) {
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// authorized returns whether the authenticated user is an admin user, and writes an error
+// otherwise.
+func (a *Admin) authorized(w http.ResponseWriter, r *http.Request) bool {
 	creds := googleauth.User(r.Context())
 	if len(a.allowed) > 0 && !a.allowed[creds.Email] {
 		http.Error(w, fmt.Sprintf("User %s (%s) not allowed", creds.Name, creds.Email), http.StatusForbidden)
-		return
+		return false
 	}
+	return true
+}
 
+func (a *Admin) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !a.authorized(w, r) {
+		return
+	}
+	creds := googleauth.User(r.Context())
 	log.Printf("User logged in: %s (%s)", creds.Name, creds.Email)
 
 	switch r.Method {
@@ -107,28 +159,86 @@ func (a *Admin) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 				return
 			}
 
-			log.Printf("Preparing backup...")
-			err = backup(a.path)
+			err = a.write(formattedData)
 			if err != nil {
-				log.Printf("Failed preparing backup: %s", err)
 				http.Error(w, "Internal error", http.StatusInternalServerError)
 				return
 			}
+		case "qnh":
+			log.Println("Requested QNH update...")
 
-			log.Printf("Writing new config: \n\n %s\n\n", string(data))
-			err = os.WriteFile(a.path, formattedData, 0)
+			qnh, err := strconv.ParseFloat(r.Form.Get("qnh"), 64)
+			if err != nil || qnh <= 0 {
+				log.Printf("Invalid QNH %q: %v", r.Form.Get("qnh"), err)
+				http.Error(w, "Invalid QNH", http.StatusBadRequest)
+				return
+			}
+			var v map[string]interface{}
+			err = json.Unmarshal([]byte(a.data), &v)
 			if err != nil {
-				log.Printf("Failed writing config %s: %s", a.path, err)
+				log.Printf("Failed unmarshaling config: %s", err)
 				http.Error(w, "Internal error", http.StatusInternalServerError)
 				return
 			}
-			log.Println("Resetting server...")
-			a.reset()
+			v["QNH"] = qnh
+			formattedData, err := json.MarshalIndent(v, "", "  ")
+			if err != nil {
+				log.Printf("Failed marshaling data %+v: %s", v, err)
+				http.Error(w, "Internal error", http.StatusInternalServerError)
+				return
+			}
+
+			err = a.write(formattedData)
+			if err != nil {
+				http.Error(w, "Internal error", http.StatusInternalServerError)
+				return
+			}
+		case "device":
+			key, value := r.Form.Get("key"), r.Form.Get("value")
+			log.Printf("Requested device config update %s=%s...", key, value)
+
+			if a.device == nil || !validKey(key) {
+				http.Error(w, "Invalid device config", http.StatusBadRequest)
+				return
+			}
+			applied, err := a.device.SetConfig(r.Context(), key, value)
+			if err != nil {
+				log.Printf("Failed setting device config: %s", err)
+				http.Error(w, fmt.Sprintf("Failed setting device config: %s", err), http.StatusBadGateway)
+				return
+			}
+			log.Printf("Device config %s set to %s", key, applied)
+		case "igc":
+			log.Println("Requested IGC download...")
+
+			if a.device == nil || a.cfg.IGCDir == "" {
+				http.Error(w, "IGC download is disabled", http.StatusBadRequest)
+				return
+			}
+			paths, err := a.device.DownloadIGC(r.Context(), a.cfg.IGCDir)
+			if err != nil {
+				log.Printf("Failed downloading IGC files: %s", err)
+				http.Error(w, fmt.Sprintf("Failed downloading IGC files: %s", err), http.StatusBadGateway)
+				return
+			}
+			log.Printf("Downloaded %d IGC files to %s", len(paths), a.cfg.IGCDir)
 		default:
 			log.Printf("Admin got unknown mode: %s", m)
 		}
 	case http.MethodGet:
-		err := a.tmpl.Execute(w, a.data)
+		d := pageData{Config: a.data, ShowDevice: a.device != nil, DeviceKeys: writableKeys()}
+		d.ShowIGC = d.ShowDevice && a.cfg.IGCDir != ""
+		if a.device != nil {
+			var err error
+			d.Status, err = a.device.DeviceStatus()
+			if err == nil {
+				d.Device, err = a.device.DeviceConfig(r.Context())
+			}
+			if err != nil {
+				d.DeviceErr = err.Error()
+			}
+		}
+		err := a.tmpl.Execute(w, d)
 		if err != nil {
 			log.Printf("Failed executing template: %s", err)
 			http.Error(w, "Internal error", http.StatusInternalServerError)
@@ -137,6 +247,47 @@ func (a *Admin) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// write backs up the current config, writes the given config and resets the server.
+func (a *Admin) write(data []byte) error {
+	log.Printf("Preparing backup...")
+	err := backup(a.path)
+	if err != nil {
+		log.Printf("Failed preparing backup: %s", err)
+		return err
+	}
+
+	log.Printf("Writing new config: \n\n %s\n\n", string(data))
+	err = os.WriteFile(a.path, data, 0)
+	if err != nil {
+		log.Printf("Failed writing config %s: %s", a.path, err)
+		return err
+	}
+	log.Println("Resetting server...")
+	a.reset()
+	return nil
+}
+
+// writableKeys returns the device configuration keys that can be set. The baud rate is not
+// writable, since the serial port keeps reading in the old baud rate after it is changed.
+func writableKeys() []string {
+	var keys []string
+	for _, k := range flarmport.ConfigKeys {
+		if k != flarmport.ConfigBaud {
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+
+func validKey(key string) bool {
+	for _, k := range writableKeys() {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
 func mode(v url.Values) string {
 	if len(v["mode"]) == 0 {
 		return ""
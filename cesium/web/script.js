@@ -300,9 +300,119 @@ function setMarkerText(msg){
                         `Climb: ${msg.Climb}m/s\n` +
                         `Heading: ${msg.Dir}°`;
 	}
+	return marker + setBaroText(msg);
+}
+
+function setStatusText(msg){
+	var text = `RX: ${msg.Rx} TX: ${msg.Tx}\n` +
+		`GPS: ${msg.GPS}\n` +
+		`Power: ${msg.Power ? "OK" : "voltage error"}`;
+	if (msg.Intruder) {
+		text = text + `\n` +
+			`Alarm: ${msg.AlarmType} (${msg.AlarmLevel})\n` +
+			`Intruder: ${msg.Intruder.Name}\n` +
+			`Bearing: ${msg.Intruder.RelativeBearing}°\n` +
+			`Distance: ${msg.Intruder.RelativeDistance}m\n` +
+			`Vertical: ${msg.Intruder.RelativeVertical}m`;
+	}
+	return text;
+}
+
+function watchStatus() {
+    var ws = new WebSocket(window.location.origin.replace("http", "ws") + "/ws/status");
+
+    ws.onmessage = function (evt) {
+        const msg = JSON.parse(evt.data);
+        const status = document.getElementById("status");
+        status.textContent = setStatusText(msg);
+        status.className = msg.AlarmLevel > 0 ? "alarm" : "";
+    };
+}
+
+// Height of the cylinder that shows range-only targets, in m.
+const rangeOnlyHeight = 150;
+// Time in ms after which a range-only target that was not updated is removed. Targets without an
+// address can't be told apart, so each of their reports is shown for a short time.
+const rangeOnlyTimeout = 10000;
+const rangeOnlyAnonymousTimeout = 2000;
+// Timers that remove range-only targets, by entity id.
+const rangeOnlyTimers = {};
+var rangeOnlyCount = 0;
+
+// Returns the entity id of a range-only target: its address, or a per-report id if it has no
+// address, since all the targets without an address have the same name.
+function rangeOnlyID(msg) {
+    if (msg.Address) {
+        return `range-only ${msg.Address}`;
+    }
+    rangeOnlyCount++;
+    return `range-only #${rangeOnlyCount}`;
+}
+
+function setRangeOnlyText(msg){
+	var marker = `${msg.Name}\n`;
+	switch(units){
+		case "metric":
+			marker = marker +
+			`Range: ${Math.round(msg.Distance)}m\n` +
+			`Rel alt: ${msg.RelativeAlt}m`;
+		break;
+		default:
+			marker = marker +
+			`Range: ${(msg.Distance/1852).toFixed(1)}NM\n` +
+			`Rel alt: ${Math.round(msg.RelativeAlt*3.28084)}ft`;
+	}
 	return marker;
 }
 
+// Draws a non-directional target as a cylinder around the station, since its bearing is unknown.
+function drawRangeOnly(msg) {
+    const id = rangeOnlyID(msg);
+    var entity = viewer.entities.getById(id);
+    if (!entity) {
+        console.log(`Creating ${id}.`);
+        entity = viewer.entities.add({
+            id: id,
+            description: `${msg.Name}`,
+            cylinder: {
+                length: rangeOnlyHeight,
+                material: Cesium.Color.ORANGE.withAlpha(0.2),
+                outline: true,
+                outlineColor: Cesium.Color.ORANGE,
+            },
+        });
+    }
+    entity.position = Cesium.Cartesian3.fromDegrees(msg.Long, msg.Lat, msg.Alt + altFix);
+    entity.cylinder.topRadius = msg.Distance;
+    entity.cylinder.bottomRadius = msg.Distance;
+    entity.label = {
+        text: setRangeOnlyText(msg),
+        font: '16pt monospace',
+        fillColor: Cesium.Color.BLACK,
+        horizontalOrigin: Cesium.HorizontalOrigin.LEFT,
+        pixelOffset: new Cesium.Cartesian2(0, -50),
+        scaleByDistance: new Cesium.NearFarScalar(0.0, 1.0, 1.0e4, 0.5)
+    };
+
+    clearTimeout(rangeOnlyTimers[id]);
+    rangeOnlyTimers[id] = setTimeout(() => {
+        viewer.entities.removeById(id);
+        delete rangeOnlyTimers[id];
+    }, msg.Address ? rangeOnlyTimeout : rangeOnlyAnonymousTimeout);
+}
+
+function setBaroText(msg){
+	if (msg.QNHAlt == null) {
+		return ``;
+	}
+	switch(units){
+		case "metric":
+			return `\nQNH: ${Math.round(msg.QNHAlt)}m FL${msg.FlightLevel}`;
+		default:
+			return `\nQNH: ${Math.round(msg.QNHAlt*3.28084)}ft FL${msg.FlightLevel}`;
+	}
+}
+
 function main() {
     drawLLMGCTR();
     drawCircuit();
@@ -313,6 +423,8 @@ function main() {
         return;
     }
 
+    watchStatus();
+
     var ws = new WebSocket(window.location.origin.replace("http", "ws") + "/ws");
 
     ws.onopen = function () {
@@ -321,6 +433,10 @@ function main() {
 	
     ws.onmessage = function (evt) {
         const msg = JSON.parse(evt.data);
+        if (msg.Kind == "range-only") {
+            drawRangeOnly(msg);
+            return;
+        }
         const position = Cesium.Cartesian3.fromDegrees(msg.Long, msg.Lat, msg.Alt + altFix);
         const time = Cesium.JulianDate.fromIso8601(msg.Time);
         const id = msg.Name;
@@ -0,0 +1,53 @@
+package flarmport
+
+import "encoding/json"
+
+// Aircraft is information about a known aircraft, used to map its address to a name.
+type Aircraft struct {
+	// Name to display for the aircraft.
+	Name string
+	// Registration of the aircraft, e.g. "4X-GBH".
+	Registration string `json:",omitempty"`
+	// CompetitionID of the aircraft, e.g. "BH".
+	CompetitionID string `json:",omitempty"`
+}
+
+// UnmarshalJSON unmarshals an aircraft from a JSON object, or from a JSON string that is used as
+// the aircraft name.
+func (a *Aircraft) UnmarshalJSON(b []byte) error {
+	var name string
+	if err := json.Unmarshal(b, &name); err == nil {
+		*a = Aircraft{Name: name}
+		return nil
+	}
+	// Use a different type to avoid recursion.
+	type aircraft Aircraft
+	return json.Unmarshal(b, (*aircraft)(a))
+}
+
+// displayName returns the name to display for the aircraft with the given address.
+func (a Aircraft) displayName(address string) string {
+	switch {
+	case a.Name != "":
+		return a.Name
+	case a.Registration != "":
+		return a.Registration
+	}
+	return address
+}
+
+// ognAddressType converts OGN address type to the address types that are used in PFLAA.
+// 0 = random, 1 = ICAO, 2 = FLARM, 3 = OGN.
+func ognAddressType(v string) string {
+	switch v {
+	case "0":
+		return "anonymous"
+	case "1":
+		return "official"
+	case "2":
+		return "flarm id"
+	case "3":
+		return "ogn"
+	}
+	return "unknown"
+}
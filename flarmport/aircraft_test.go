@@ -0,0 +1,33 @@
+package flarmport
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAircraftUnmarshalJSON(t *testing.T) {
+	t.Parallel()
+
+	in := `{
+		"DD8E8B": "APL",
+		"DDFD21": {"Name": "GBH", "Registration": "4X-GBH", "CompetitionID": "BH"},
+		"DD8E69": {"Registration": "4X-GAY"}
+	}`
+	var got map[string]Aircraft
+	require.NoError(t, json.Unmarshal([]byte(in), &got))
+
+	want := map[string]Aircraft{
+		"DD8E8B": {Name: "APL"},
+		"DDFD21": {Name: "GBH", Registration: "4X-GBH", CompetitionID: "BH"},
+		"DD8E69": {Registration: "4X-GAY"},
+	}
+	assert.Equal(t, want, got)
+
+	si := StationInfo{IDMap: got}
+	assert.Equal(t, "APL", si.MapID("DD8E8B"))
+	assert.Equal(t, "4X-GAY", si.MapID("DD8E69"))
+	assert.Equal(t, "DDFD1D", si.MapID("DDFD1D"))
+}
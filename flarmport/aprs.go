@@ -0,0 +1,253 @@
+package flarmport
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultAPRSCallsign  = "N0CALL"
+	defaultAPRSKeepAlive = 4 * time.Minute
+	aprsSoftware         = "flarm 1.0"
+
+	knotsToMS = 0.514444
+	fpmToMS   = 0.00508
+	// One rot is a half turn per minute.
+	rotToDegS = 3
+)
+
+// APRSConfig is configuration for connecting to an APRS-IS server.
+type APRSConfig struct {
+	// Callsign to login with. Default is N0CALL.
+	Callsign string
+	// Passcode of the callsign. Zero logs in as a read-only client.
+	Passcode int
+	// Filter is the server-side filter, e.g. "r/32.6/35.2/100". If empty, a range filter around
+	// the station is used.
+	Filter string
+	// Range of the default filter, in km. Default is 100km.
+	Range float64
+	// KeepAliveSec is the interval in seconds for sending keepalive messages. Default is 4m.
+	KeepAliveSec int
+}
+
+func (c APRSConfig) login(station StationInfo) string {
+	callsign := c.Callsign
+	if callsign == "" {
+		callsign = defaultAPRSCallsign
+	}
+	passcode := c.Passcode
+	if passcode == 0 {
+		passcode = -1
+	}
+	filter := c.Filter
+	if filter == "" {
+		r := c.Range
+		if r == 0 {
+			r = 100
+		}
+		filter = fmt.Sprintf("r/%.4f/%.4f/%g", station.Lat, station.Long, r)
+	}
+	return fmt.Sprintf("user %s pass %d vers %s filter %s\r\n", callsign, passcode, aprsSoftware, filter)
+}
+
+// APRS is a connection to an APRS-IS server, that reads OGN aircraft beacons.
+// Specification at: http://wiki.glidernet.org/wiki:subscribe-to-ogn-data.
+type APRS struct {
+	scanner *bufio.Scanner
+	conn    io.WriteCloser
+	station StationInfo
+	done    chan struct{}
+	once    sync.Once
+}
+
+// OpenAPRS connects and logs in to an APRS-IS server in the given address.
+func OpenAPRS(addr string, station StationInfo, cfg APRSConfig) (*APRS, error) {
+	conn, err := net.DialTimeout("tcp", addr, time.Second*10)
+	if err != nil {
+		return nil, fmt.Errorf("failed connecting to aprs: %v", err)
+	}
+
+	if station.TimeZone == nil {
+		station.TimeZone = defaultTimezone
+	}
+
+	_, err = io.WriteString(conn, cfg.login(station))
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed login to aprs: %v", err)
+	}
+
+	a := &APRS{
+		scanner: bufio.NewScanner(conn),
+		conn:    conn,
+		station: station,
+		done:    make(chan struct{}),
+	}
+
+	keepAlive := time.Duration(cfg.KeepAliveSec) * time.Second
+	if keepAlive == 0 {
+		keepAlive = defaultAPRSKeepAlive
+	}
+	go a.keepAlive(keepAlive)
+	return a, nil
+}
+
+// Range iterates over the positions of other aircraft. See RangeHandler for reading all values.
+func (a *APRS) Range(ctx context.Context, f func(Data)) error {
+	return a.RangeHandler(ctx, Handler{Data: f})
+}
+
+// RangeHandler iterates and parses data from the APRS connection. It exists when the connection is
+// closed.
+func (a *APRS) RangeHandler(ctx context.Context, h Handler) error {
+	for ctx.Err() == nil {
+		value, ok := a.next()
+		if !ok {
+			return nil
+		}
+		if ctx.Err() == nil {
+			h.handle(value)
+		}
+	}
+	return ctx.Err()
+}
+
+// Close stops the keepalive and closes the connection.
+func (a *APRS) Close() error {
+	a.once.Do(func() { close(a.done) })
+	return a.conn.Close()
+}
+
+func (a *APRS) keepAlive(interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-a.done:
+			return
+		case <-t.C:
+			_, err := io.WriteString(a.conn, "# keepalive\r\n")
+			if err != nil {
+				log.Printf("Failed sending aprs keepalive: %v", err)
+				return
+			}
+		}
+	}
+}
+
+// next used by Range and exist for testing purposes.
+func (a *APRS) next() (*Data, bool) {
+	if !a.scanner.Scan() {
+		// Stop scanning.
+		return nil, false
+	}
+	line := a.scanner.Text()
+	// Server comments.
+	if strings.HasPrefix(line, "#") {
+		return nil, true
+	}
+	b := parseAPRS(line)
+	if b == nil || b.noTrack {
+		return nil, true
+	}
+	t, ok := a.station.timestamp(timeOfDay(b.hhmmss, time.Now()))
+	if !ok {
+		return nil, true
+	}
+	b.data.Time = t
+	a.station.identify(&b.data, b.address, b.addressType)
+	return &b.data, true
+}
+
+// aprsPattern matches APRS position reports with timestamps, for example:
+// `FLRDDA5BA>APRS,qAS,LFMX:/165829h4415.41N/00600.03E'342/049/A=005524 id0ADDA5BA -454fpm`.
+// Groups: 1: time, 2-3: latitude, 4-5: longitude, 6: course, 7: speed, 8: altitude, 9: comment.
+var aprsPattern = regexp.MustCompile(`^[^>]+>[^:]+:[/@](\d{6})h(\d{4}\.\d{2})([NS]).(\d{5}\.\d{2})([EW]).(?:(\d{3})/(\d{3}))?/A=(-?\d+)(.*)$`)
+
+// aprsIDPattern matches the OGN id field. The first byte is STttttaa: S - stealth, T - no tracking,
+// tttt - aircraft type, aa - address type. It is followed by the address.
+var aprsIDPattern = regexp.MustCompile(`^id([0-9A-F]{2})([0-9A-F]{6})$`)
+
+// aprsBeacon is a parsed OGN APRS aircraft beacon.
+type aprsBeacon struct {
+	data        Data
+	hhmmss      string
+	address     string
+	addressType string
+	noTrack     bool
+}
+
+// parseAPRS parses an OGN aircraft beacon. It returns nil if the line is not an aircraft beacon.
+func parseAPRS(line string) *aprsBeacon {
+	m := aprsPattern.FindStringSubmatch(line)
+	if len(m) < 10 {
+		return nil
+	}
+	b := aprsBeacon{
+		hhmmss: m[1],
+		data:   Data{Kind: KindPosition, Source: SourceAPRS},
+	}
+	latMin, _ := strconv.ParseFloat(m[2][2:], 64)
+	longMin, _ := strconv.ParseFloat(m[4][3:], 64)
+
+	hasID := false
+	for _, field := range strings.Fields(m[9]) {
+		switch {
+		case strings.HasPrefix(field, "!W") && len(field) == 5:
+			// Precision enhancement adds a third decimal digit to the minutes.
+			latMin += float64(field[2]-'0') / 1000
+			longMin += float64(field[3]-'0') / 1000
+		case aprsIDPattern.MatchString(field):
+			id := aprsIDPattern.FindStringSubmatch(field)
+			flags, _ := strconv.ParseUint(id[1], 16, 8)
+			b.address = id[2]
+			b.addressType = ognAddressType(strconv.Itoa(int(flags & 0x3)))
+			b.data.Type = aircraftType(fmt.Sprintf("%X", (flags>>2)&0xF))
+			b.noTrack = flags&0x40 != 0
+			// Stealth aircraft are reported as anonymous, similarly to the flarm.
+			if flags&0x80 != 0 {
+				b.addressType = "anonymous"
+			}
+			hasID = true
+		case strings.HasSuffix(field, "fpm"):
+			fpm, _ := strconv.ParseFloat(strings.TrimSuffix(field, "fpm"), 64)
+			b.data.Climb = math.Round(fpm*fpmToMS*10) / 10
+		case strings.HasSuffix(field, "rot"):
+			rot, _ := strconv.ParseFloat(strings.TrimSuffix(field, "rot"), 64)
+			b.data.TurnRate = rot * rotToDegS
+		}
+	}
+	if !hasID {
+		// Not an aircraft beacon.
+		return nil
+	}
+
+	deg, _ := strconv.ParseFloat(m[2][:2], 64)
+	b.data.Lat = deg + latMin/60
+	if m[3] == "S" {
+		b.data.Lat = -b.data.Lat
+	}
+	deg, _ = strconv.ParseFloat(m[4][:3], 64)
+	b.data.Long = deg + longMin/60
+	if m[5] == "W" {
+		b.data.Long = -b.data.Long
+	}
+	dir, _ := strconv.Atoi(m[6])
+	b.data.Dir = dir
+	speed, _ := strconv.ParseFloat(m[7], 64)
+	b.data.GroundSpeed = int64(math.Round(speed * knotsToMS))
+	alt, _ := strconv.ParseFloat(m[8], 64)
+	b.data.Alt = alt * feetToMeters
+	return &b
+}
@@ -0,0 +1,110 @@
+package flarmport
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testAPRS = `# aprsc 2.1.4-g408ed49
+# logresp N0CALL unverified, server GLIDERN1
+FLRDDA5BA>APRS,qAS,LFMX:/165829h4415.41N/00600.03E'342/049/A=005524 !W52! id0ADDA5BA -454fpm -1.0rot 8.8dB 0e +51.2kHz gps4x5
+FLRDDA5BB>APRS,qAS,LFMX:/165829h4415.41N/00600.03E'342/049/A=005524 id4ADDA5BB -454fpm -1.0rot
+LFMX>APRS,TCPIP*,qAC,GLIDERN1:/165833h4415.48NI00600.05E&/A=001000 v0.2.8.RPI-GPU CPU:0.7
+FLRDDA5BC>APRS,qAS,LFMX:/165829h3235.00S/03514.00W'090/010/A=001000 id85DDA5BC +100fpm +0.0rot
+`
+
+func TestAPRS(t *testing.T) {
+	t.Parallel()
+
+	l, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
+	defer l.Close()
+
+	login := make(chan string, 1)
+	keepAlive := make(chan string, 1)
+	go func() {
+		conn, err := l.Accept()
+		require.NoError(t, err)
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		line, err := r.ReadString('\n')
+		require.NoError(t, err)
+		login <- line
+
+		_, err = conn.Write([]byte(testAPRS))
+		require.NoError(t, err)
+
+		line, err = r.ReadString('\n')
+		require.NoError(t, err)
+		keepAlive <- line
+	}()
+
+	station := StationInfo{Lat: 44.25, Long: 6, IDMap: map[string]Aircraft{"DDA5BA": {Name: "APL"}}}
+	a, err := OpenAPRS(l.Addr().String(), station, APRSConfig{Range: 50, KeepAliveSec: 1})
+	require.NoError(t, err)
+	defer a.Close()
+
+	assert.Equal(t, "user N0CALL pass -1 vers flarm 1.0 filter r/44.2500/6.0000/50\r\n", <-login)
+
+	// Server comments.
+	for i := 0; i < 2; i++ {
+		got, ok := a.next()
+		assert.True(t, ok)
+		assert.Nil(t, got)
+	}
+
+	got, ok := a.next()
+	require.True(t, ok)
+	require.NotNil(t, got)
+	assert.Equal(t, "16:58:29", got.Time.UTC().Format("15:04:05"))
+	got.Time = time.Time{}
+	assert.InDelta(t, 44+15.415/60, got.Lat, 1e-9)
+	assert.InDelta(t, 6+0.032/60, got.Long, 1e-9)
+	assert.InDelta(t, 1683.7152, got.Alt, 1e-6)
+	assert.InDelta(t, -3, got.TurnRate, 1e-9)
+	got.Lat, got.Long, got.Alt, got.TurnRate = 0, 0, 0, 0
+	want := &Data{
+		Kind:        KindPosition,
+		Source:      SourceAPRS,
+		Name:        "APL",
+		Address:     "DDA5BA",
+		AddressType: "flarm id",
+		Type:        "towplane",
+		Dir:         342,
+		GroundSpeed: 25,
+		Climb:       -2.3,
+	}
+	assert.Equal(t, want, got)
+
+	// No tracking aircraft are ignored.
+	got, ok = a.next()
+	assert.True(t, ok)
+	assert.Nil(t, got)
+
+	// Receiver beacons are ignored.
+	got, ok = a.next()
+	assert.True(t, ok)
+	assert.Nil(t, got)
+
+	// Stealth aircraft.
+	got, ok = a.next()
+	require.True(t, ok)
+	require.NotNil(t, got)
+	assert.Equal(t, "anonymous", got.AddressType)
+	assert.Equal(t, "glider", got.Type)
+	assert.InDelta(t, -(32 + 35.0/60), got.Lat, 1e-9)
+	assert.InDelta(t, -(35 + 14.0/60), got.Long, 1e-9)
+
+	select {
+	case line := <-keepAlive:
+		assert.True(t, strings.HasPrefix(line, "#"))
+	case <-time.After(5 * time.Second):
+		t.Fatal("Keepalive was not sent")
+	}
+}
@@ -0,0 +1,295 @@
+package flarmport
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultAPRSUplinkBeacon    = 5 * time.Minute
+	defaultAPRSUplinkQueueSize = 256
+	aprsUplinkRetryDelay       = 30 * time.Second
+)
+
+// aprsCallsignPattern matches valid receiver callsigns.
+var aprsCallsignPattern = regexp.MustCompile(`^[A-Za-z0-9]{3,9}$`)
+
+// APRSUplinkConfig is configuration for uploading the flarm receptions to the OGN network through
+// an APRS-IS server.
+type APRSUplinkConfig struct {
+	// Addr of the APRS-IS server, e.g. "aprs.glidernet.org:14580". If empty, the uplink is
+	// disabled.
+	Addr string
+	// Callsign is the name of the receiver, up to 9 alphanumeric characters. It is required.
+	Callsign string
+	// Passcode of the callsign. Default is the passcode that is computed from the callsign.
+	Passcode int
+	// BeaconSec is the interval in seconds for sending receiver beacons. Default is 5m.
+	BeaconSec int
+	// QueueSize is the number of positions that are queued while not connected. Default is 256.
+	QueueSize int
+}
+
+// APRSUplink is a connection to an APRS-IS server as an OGN receiver. It periodically sends
+// receiver beacons with the station location, and forwards the aircraft that are received by the
+// flarm as OGN aircraft beacons. It reconnects when the connection is lost.
+type APRSUplink struct {
+	cfg     APRSUplinkConfig
+	station StationInfo
+	queue   chan string
+	done    chan struct{}
+	once    sync.Once
+	wg      sync.WaitGroup
+}
+
+// NewAPRSUplink starts uploading to an APRS-IS server. It returns nil if the uplink is disabled in
+// the configuration.
+func NewAPRSUplink(cfg APRSUplinkConfig, station StationInfo) (*APRSUplink, error) {
+	if cfg.Addr == "" {
+		return nil, nil
+	}
+	if !aprsCallsignPattern.MatchString(cfg.Callsign) {
+		return nil, fmt.Errorf("invalid aprs uplink callsign %q", cfg.Callsign)
+	}
+	if cfg.Passcode == 0 {
+		cfg.Passcode = aprsPasscode(cfg.Callsign)
+	}
+	if cfg.BeaconSec == 0 {
+		cfg.BeaconSec = int(defaultAPRSUplinkBeacon / time.Second)
+	}
+	if cfg.QueueSize == 0 {
+		cfg.QueueSize = defaultAPRSUplinkQueueSize
+	}
+	u := &APRSUplink{
+		cfg:     cfg,
+		station: station,
+		queue:   make(chan string, cfg.QueueSize),
+		done:    make(chan struct{}),
+	}
+	u.wg.Add(1)
+	go u.run()
+	return u, nil
+}
+
+// Data forwards aircraft that were received by the flarm. Data from other sources, such as OGN or
+// replay, and range-only targets are not forwarded. The position is dropped if the queue is full.
+func (u *APRSUplink) Data(d Data) {
+	if u == nil || !strings.HasPrefix(d.Source, SourceFlarm) {
+		return
+	}
+	line, ok := formatAPRSBeacon(d, u.cfg.Callsign)
+	if !ok {
+		return
+	}
+	select {
+	case u.queue <- line:
+	default:
+	}
+}
+
+// Close disconnects from the server.
+func (u *APRSUplink) Close() error {
+	if u == nil {
+		return nil
+	}
+	u.once.Do(func() { close(u.done) })
+	u.wg.Wait()
+	return nil
+}
+
+// run connects to the server and sends the beacons until the uplink is closed.
+func (u *APRSUplink) run() {
+	defer u.wg.Done()
+	for {
+		err := u.connect()
+		select {
+		case <-u.done:
+			return
+		default:
+		}
+		log.Printf("APRS uplink disconnected: %v. Reconnecting in %s", err, aprsUplinkRetryDelay)
+		select {
+		case <-u.done:
+			return
+		case <-time.After(aprsUplinkRetryDelay):
+		}
+	}
+}
+
+// connect logs in to the server and sends beacons until the connection fails or the uplink is
+// closed.
+func (u *APRSUplink) connect() error {
+	conn, err := net.DialTimeout("tcp", u.cfg.Addr, time.Second*10)
+	if err != nil {
+		return fmt.Errorf("failed connecting: %v", err)
+	}
+	defer conn.Close()
+
+	// Read the server responses, and detect when the connection is closed by the server.
+	closed := make(chan error, 1)
+	go func() {
+		scanner := bufio.NewScanner(conn)
+		for scanner.Scan() {
+			if line := scanner.Text(); strings.HasPrefix(line, "# logresp") {
+				log.Printf("APRS uplink: %s", line)
+			}
+		}
+		err := scanner.Err()
+		if err == nil {
+			err = io.EOF
+		}
+		closed <- err
+	}()
+
+	login := fmt.Sprintf("user %s pass %d vers %s\r\n", u.cfg.Callsign, u.cfg.Passcode, aprsSoftware)
+	send := func(lines ...string) error {
+		for _, line := range lines {
+			conn.SetWriteDeadline(time.Now().Add(time.Second * 10))
+			_, err := io.WriteString(conn, line)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	err = send(login)
+	if err != nil {
+		return fmt.Errorf("failed login: %v", err)
+	}
+
+	t := time.NewTicker(time.Duration(u.cfg.BeaconSec) * time.Second)
+	defer t.Stop()
+	now := time.Now()
+	for {
+		err = send(formatAPRSReceiverBeacons(u.cfg.Callsign, u.station, now)...)
+		if err != nil {
+			return fmt.Errorf("failed sending receiver beacon: %v", err)
+		}
+		for sending := true; sending; {
+			select {
+			case <-u.done:
+				return nil
+			case err := <-closed:
+				return err
+			case line := <-u.queue:
+				err = send(line)
+				if err != nil {
+					return fmt.Errorf("failed sending beacon: %v", err)
+				}
+			case now = <-t.C:
+				sending = false
+			}
+		}
+	}
+}
+
+// formatAPRSReceiverBeacons returns the receiver position and status beacons of the station.
+func formatAPRSReceiverBeacons(callsign string, station StationInfo, t time.Time) []string {
+	hhmmss := t.UTC().Format("150405")
+	lat, long := aprsCoordinates(station.Lat, station.Long)
+	return []string{
+		fmt.Sprintf("%s>OGNSDR,TCPIP*:/%sh%sI%s&/A=%06d\r\n",
+			callsign, hhmmss, lat, long, int(math.Round(station.Alt/feetToMeters))),
+		fmt.Sprintf("%s>OGNSDR,TCPIP*:>%sh %s\r\n", callsign, hhmmss, aprsSoftware),
+	}
+}
+
+// formatAPRSBeacon returns an OGN aircraft beacon of the given data, received by the given
+// receiver. It returns false if the data can't be represented. Anonymous aircraft are sent with the
+// stealth flag set, such that they are not shown publicly.
+func formatAPRSBeacon(d Data, receiver string) (string, bool) {
+	if d.Kind == KindRangeOnly || len(d.Address) != 6 {
+		return "", false
+	}
+	var (
+		prefix = "FLR"
+		flags  byte
+	)
+	switch d.AddressType {
+	case "official":
+		prefix, flags = "ICA", 1
+	case "flarm id":
+		flags = 2
+	case "ogn":
+		prefix, flags = "OGN", 3
+	case "anonymous":
+		flags = 0x80
+	}
+	tp := aircraftTypeCode(d.Type)
+	typeCode, _ := strconv.ParseUint(tp, 16, 8)
+	flags |= byte(typeCode) << 2
+
+	lat, long, enhancement := aprsPreciseCoordinates(d.Lat, d.Long)
+	symbol := aprsSymbol(tp)
+	line := fmt.Sprintf("%s%s>OGFLR,qAS,%s:/%sh%s%c%s%c%03d/%03d/A=%06d !W%s! id%02X%s %+dfpm",
+		prefix, d.Address, receiver, d.Time.UTC().Format("150405"), lat, symbol[0], long, symbol[1],
+		d.Dir%360, int(math.Round(float64(d.GroundSpeed)/knotsToMS)),
+		int(math.Round(d.Alt/feetToMeters)), enhancement, flags, d.Address,
+		int(math.Round(d.Climb/fpmToMS)))
+	if d.TurnRate != 0 {
+		line += fmt.Sprintf(" %+.1frot", d.TurnRate/rotToDegS)
+	}
+	return line + "\r\n", true
+}
+
+// aprsCoordinates formats coordinates in the APRS degrees and minutes format with two decimal
+// digits.
+func aprsCoordinates(lat, long float64) (string, string) {
+	lat, long = math.Round(lat*60*100)/60/100, math.Round(long*60*100)/60/100
+	latDeg, ns, longDeg, ew := nmeaCoordinates(lat, long)
+	return latDeg[:7] + ns, longDeg[:8] + ew
+}
+
+// aprsPreciseCoordinates formats coordinates in the APRS degrees and minutes format, with the
+// third decimal digit of the minutes in the precision enhancement.
+func aprsPreciseCoordinates(lat, long float64) (string, string, string) {
+	lat, long = math.Round(lat*60*1000)/60/1000, math.Round(long*60*1000)/60/1000
+	latDeg, ns, longDeg, ew := nmeaCoordinates(lat, long)
+	return latDeg[:7] + ns, longDeg[:8] + ew, latDeg[7:8] + longDeg[8:9]
+}
+
+// aprsSymbol returns the APRS symbol table and code of an aircraft type code.
+func aprsSymbol(tp string) string {
+	switch tp {
+	case "1", "2", "8":
+		return "/'"
+	case "3":
+		return "/X"
+	case "4", "6", "7":
+		return "/g"
+	case "5", "D":
+		return `\^`
+	case "9":
+		return "/^"
+	case "B", "C":
+		return "/O"
+	case "F":
+		return `\n`
+	}
+	return "/z"
+}
+
+// aprsPasscode returns the APRS-IS passcode of a callsign.
+func aprsPasscode(callsign string) int {
+	if i := strings.Index(callsign, "-"); i >= 0 {
+		callsign = callsign[:i]
+	}
+	callsign = strings.ToUpper(callsign)
+	hash := 0x73e2
+	for i := 0; i < len(callsign); i += 2 {
+		hash ^= int(callsign[i]) << 8
+		if i+1 < len(callsign) {
+			hash ^= int(callsign[i+1])
+		}
+	}
+	return hash & 0x7fff
+}
@@ -0,0 +1,141 @@
+package flarmport
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFormatAPRSBeacon(t *testing.T) {
+	t.Parallel()
+
+	d := Data{
+		Kind:        KindPosition,
+		Address:     "DD8E8B",
+		AddressType: "flarm id",
+		Lat:         32.51234,
+		Long:        -35.25678,
+		Alt:         1000,
+		Dir:         90,
+		GroundSpeed: 30,
+		Climb:       -2.3,
+		TurnRate:    6,
+		Type:        "glider",
+		Time:        time.Date(2020, 12, 17, 14, 35, 36, 0, time.FixedZone("IST", 2*60*60)),
+	}
+	line, ok := formatAPRSBeacon(d, "Megido")
+	require.True(t, ok)
+	assert.Equal(t,
+		"FLRDD8E8B>OGFLR,qAS,Megido:/123536h3230.74N/03515.40W'090/058/A=003281 !W07! id06DD8E8B -453fpm +2.0rot\r\n",
+		line)
+
+	// The beacon can be parsed.
+	b := parseAPRS(strings.TrimSpace(line))
+	require.NotNil(t, b)
+	assert.Equal(t, "DD8E8B", b.address)
+	assert.Equal(t, "flarm id", b.addressType)
+	assert.Equal(t, "glider", b.data.Type)
+	assert.InDelta(t, d.Lat, b.data.Lat, 1e-5)
+	assert.InDelta(t, d.Long, b.data.Long, 1e-5)
+	assert.InDelta(t, d.Alt, b.data.Alt, 0.5)
+	assert.Equal(t, d.Dir, b.data.Dir)
+	assert.Equal(t, d.GroundSpeed, b.data.GroundSpeed)
+	assert.Equal(t, d.Climb, b.data.Climb)
+	assert.Equal(t, d.TurnRate, b.data.TurnRate)
+
+	// Anonymous aircraft are sent with the stealth flag.
+	d.AddressType = "anonymous"
+	line, ok = formatAPRSBeacon(d, "Megido")
+	require.True(t, ok)
+	assert.Contains(t, line, " id84DD8E8B ")
+	b = parseAPRS(strings.TrimSpace(line))
+	require.NotNil(t, b)
+	assert.Equal(t, "anonymous", b.addressType)
+
+	// Official address.
+	d.AddressType = "official"
+	line, ok = formatAPRSBeacon(d, "Megido")
+	require.True(t, ok)
+	assert.True(t, strings.HasPrefix(line, "ICADD8E8B>"), line)
+
+	_, ok = formatAPRSBeacon(Data{Kind: KindRangeOnly, Address: "DD8E8B"}, "Megido")
+	assert.False(t, ok)
+}
+
+func TestFormatAPRSReceiverBeacons(t *testing.T) {
+	t.Parallel()
+
+	lines := formatAPRSReceiverBeacons("Megido", StationInfo{Lat: 32.5, Long: 35.2, Alt: 100},
+		time.Date(2020, 12, 17, 12, 35, 36, 0, time.UTC))
+	assert.Equal(t, []string{
+		"Megido>OGNSDR,TCPIP*:/123536h3230.00NI03512.00E&/A=000328\r\n",
+		"Megido>OGNSDR,TCPIP*:>123536h " + aprsSoftware + "\r\n",
+	}, lines)
+}
+
+func TestAPRSPasscode(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, 13023, aprsPasscode("N0CALL"))
+	assert.Equal(t, 13023, aprsPasscode("n0call-5"))
+}
+
+func TestAPRSUplink(t *testing.T) {
+	t.Parallel()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+
+	u, err := NewAPRSUplink(APRSUplinkConfig{Addr: l.Addr().String(), Callsign: "Megido"}, StationInfo{Lat: 32.5, Long: 35.2})
+	require.NoError(t, err)
+	defer u.Close()
+
+	conn, err := l.Accept()
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = conn.Write([]byte("# aprsc 2.1.4\r\n"))
+	require.NoError(t, err)
+	r := bufio.NewReader(conn)
+
+	readLine := func() string {
+		line, err := r.ReadString('\n')
+		require.NoError(t, err)
+		return line
+	}
+
+	assert.Equal(t, "user Megido pass 15777 vers "+aprsSoftware+"\r\n", readLine())
+	_, err = conn.Write([]byte("# logresp Megido verified, server GLIDERN1\r\n"))
+	require.NoError(t, err)
+	assert.Contains(t, readLine(), "Megido>OGNSDR,TCPIP*:/")
+	assert.Contains(t, readLine(), "Megido>OGNSDR,TCPIP*:>")
+
+	// Data from other sources is not forwarded.
+	u.Data(Data{Kind: KindPosition, Source: SourceOGN, Address: "111111"})
+	u.Data(Data{Kind: KindPosition, Source: "replay", Address: "222222"})
+	u.Data(Data{Kind: KindPosition, Source: SourceFlarm, Address: "DD8E8B", AddressType: "flarm id", Lat: 32.6, Long: 35.3})
+
+	b := parseAPRS(strings.TrimSpace(readLine()))
+	require.NotNil(t, b)
+	assert.Equal(t, "DD8E8B", b.address)
+	assert.InDelta(t, 32.6, b.data.Lat, 1e-5)
+}
+
+func TestAPRSUplinkConfig(t *testing.T) {
+	t.Parallel()
+
+	u, err := NewAPRSUplink(APRSUplinkConfig{}, StationInfo{})
+	require.NoError(t, err)
+	assert.Nil(t, u)
+	u.Data(Data{})
+	assert.NoError(t, u.Close())
+
+	_, err = NewAPRSUplink(APRSUplinkConfig{Addr: "localhost:14580", Callsign: "Bad-Name"}, StationInfo{})
+	assert.Error(t, err)
+}
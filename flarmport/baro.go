@@ -0,0 +1,60 @@
+package flarmport
+
+import (
+	"math"
+	"time"
+)
+
+const (
+	// StandardQNH is the standard atmospheric pressure at sea level, in hPa.
+	StandardQNH = 1013.25
+
+	feetToMeters = 0.3048
+
+	// Constants of the international standard atmosphere barometric formula.
+	isaHeight   = 44330.77
+	isaExponent = 0.190263
+)
+
+// baro is the last known barometric altitude of the flarm receiver, as reported in the PGRMZ
+// sentence.
+type baro struct {
+	// Pressure altitude of the receiver, in meters.
+	alt float64
+	// updated is the time of the last update. Zero if no altitude was received.
+	updated time.Time
+}
+
+func (b *baro) updatePGRMZ(e TypePGRMZ) {
+	b.alt = float64(e.Altitude) * feetToMeters
+	b.updated = time.Now()
+}
+
+// pressureAlt returns the pressure altitude of the receiver, and whether it is known.
+func (b *baro) pressureAlt() (float64, bool) {
+	// Barometric altitude is sent along with the GPS sentences, so it expires similarly.
+	if b.updated.IsZero() || time.Since(b.updated) > fixTimeout {
+		return 0, false
+	}
+	return b.alt, true
+}
+
+// qnhAlt converts pressure altitude to altitude that is shown by an altimeter set to the given
+// QNH, in hPa. Both altitudes are in meters.
+func qnhAlt(pressureAlt, qnh float64) float64 {
+	pressure := StandardQNH * math.Pow(1-pressureAlt/isaHeight, 1/isaExponent)
+	return isaHeight * (1 - math.Pow(pressure/qnh, isaExponent))
+}
+
+// flightLevel returns the flight level of a given pressure altitude in meters.
+func flightLevel(pressureAlt float64) int {
+	return int(math.Round(pressureAlt / feetToMeters / 100))
+}
+
+// setPressureAlt sets the barometric altitude fields of the data, given its pressure altitude in
+// meters.
+func (si StationInfo) setPressureAlt(d *Data, pressureAlt float64) {
+	fl := flightLevel(pressureAlt)
+	alt := qnhAlt(pressureAlt, si.QNH)
+	d.PressureAlt, d.FlightLevel, d.QNHAlt = &pressureAlt, &fl, &alt
+}
@@ -0,0 +1,337 @@
+package flarmport
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// FLARM binary protocol, used for downloading flights. The device enters binary mode with the
+// PFLAX sentence, and returns to NMEA mode with the exit message.
+//
+// Each message is framed with a start byte, and the start and escape bytes in the message are
+// escaped. A message has an 8 bytes header: length (2 bytes, header and payload), version (1),
+// sequence number (2), message type (1) and a CRC (2) of the header and payload. All values are
+// little endian.
+const (
+	binaryStartByte  = 0x73
+	binaryEscapeByte = 0x78
+	binaryEscStart   = 0x31
+	binaryEscEscape  = 0x55
+
+	binaryHeaderLen  = 8
+	binaryVersion    = 1
+	binaryMaxPayload = 4096
+	binaryTimeout    = 3 * time.Second
+	binaryPings      = 5
+)
+
+// Binary message types.
+const (
+	binaryError        = 0x00
+	binaryACK          = 0xA0
+	binaryPing         = 0x01
+	binaryExit         = 0x12
+	binarySelectRecord = 0x20
+	binaryRecordInfo   = 0x21
+	binaryIGCData      = 0x22
+	binaryNACK         = 0xB7
+)
+
+var (
+	// errBinaryNACK is returned when the device rejects a binary request.
+	errBinaryNACK = errors.New("device rejected request")
+	// errBinaryCorrupted is returned when a corrupted binary message is read.
+	errBinaryCorrupted = errors.New("corrupted binary message")
+	// errBinarySession is returned when a request is made while a binary session, such as a flight
+	// download, is in progress.
+	errBinarySession = errors.New("flight download in progress")
+)
+
+// binaryMessage is a message of the FLARM binary protocol.
+type binaryMessage struct {
+	seq     uint16
+	typ     byte
+	payload []byte
+}
+
+// encode returns the framed message.
+func (m binaryMessage) encode() []byte {
+	msg := make([]byte, binaryHeaderLen, binaryHeaderLen+len(m.payload))
+	binary.LittleEndian.PutUint16(msg[0:], uint16(binaryHeaderLen+len(m.payload)))
+	msg[2] = binaryVersion
+	binary.LittleEndian.PutUint16(msg[3:], m.seq)
+	msg[5] = m.typ
+	msg = append(msg, m.payload...)
+	binary.LittleEndian.PutUint16(msg[6:], binaryCRC(msg[:6], m.payload))
+
+	frame := []byte{binaryStartByte}
+	for _, b := range msg {
+		switch b {
+		case binaryStartByte:
+			frame = append(frame, binaryEscapeByte, binaryEscStart)
+		case binaryEscapeByte:
+			frame = append(frame, binaryEscapeByte, binaryEscEscape)
+		default:
+			frame = append(frame, b)
+		}
+	}
+	return frame
+}
+
+// readBinaryMessage reads the next message from the reader. Bytes before the start byte are
+// skipped. It returns an error for a corrupted message, after which the next message can be read.
+func readBinaryMessage(r *bufio.Reader) (binaryMessage, error) {
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return binaryMessage{}, err
+		}
+		if b == binaryStartByte {
+			break
+		}
+	}
+
+	read := func(n int) ([]byte, error) {
+		buf := make([]byte, 0, n)
+		for len(buf) < n {
+			b, err := r.ReadByte()
+			if err != nil {
+				return nil, err
+			}
+			switch b {
+			case binaryStartByte:
+				// Unexpected start of a new message.
+				r.UnreadByte()
+				return nil, fmt.Errorf("%w: truncated", errBinaryCorrupted)
+			case binaryEscapeByte:
+				b, err = r.ReadByte()
+				if err != nil {
+					return nil, err
+				}
+				switch b {
+				case binaryEscStart:
+					b = binaryStartByte
+				case binaryEscEscape:
+					b = binaryEscapeByte
+				default:
+					return nil, fmt.Errorf("%w: invalid escape %#x", errBinaryCorrupted, b)
+				}
+			}
+			buf = append(buf, b)
+		}
+		return buf, nil
+	}
+
+	header, err := read(binaryHeaderLen)
+	if err != nil {
+		return binaryMessage{}, err
+	}
+	length := int(binary.LittleEndian.Uint16(header[0:]))
+	if length < binaryHeaderLen || length > binaryHeaderLen+binaryMaxPayload {
+		return binaryMessage{}, fmt.Errorf("%w: invalid length %d", errBinaryCorrupted, length)
+	}
+	payload, err := read(length - binaryHeaderLen)
+	if err != nil {
+		return binaryMessage{}, err
+	}
+	if got, want := binaryCRC(header[:6], payload), binary.LittleEndian.Uint16(header[6:]); got != want {
+		return binaryMessage{}, fmt.Errorf("%w: bad crc %04x != %04x", errBinaryCorrupted, got, want)
+	}
+	return binaryMessage{
+		seq:     binary.LittleEndian.Uint16(header[3:]),
+		typ:     header[5],
+		payload: payload,
+	}, nil
+}
+
+// binaryCRC returns the CRC-CCITT (polynomial 0x1021, initial value 0) of the given data.
+func binaryCRC(data ...[]byte) uint16 {
+	var crc uint16
+	for _, d := range data {
+		for _, b := range d {
+			crc ^= uint16(b) << 8
+			for i := 0; i < 8; i++ {
+				if crc&0x8000 != 0 {
+					crc = crc<<1 ^ 0x1021
+				} else {
+					crc <<= 1
+				}
+			}
+		}
+	}
+	return crc
+}
+
+// divertReader reads from a connection, and diverts the read data to a binary session while one
+// is active.
+type divertReader struct {
+	r  io.Reader
+	mu sync.Mutex
+	w  *io.PipeWriter
+}
+
+func (d *divertReader) Read(b []byte) (int, error) {
+	for {
+		n, err := d.r.Read(b)
+		d.mu.Lock()
+		w := d.w
+		d.mu.Unlock()
+		if w == nil {
+			return n, err
+		}
+		if n > 0 {
+			// Fails if the session is closed, in which case the data is discarded.
+			w.Write(b[:n])
+		}
+		if err != nil {
+			w.CloseWithError(err)
+			return 0, err
+		}
+	}
+}
+
+// divert starts diverting the read data to the returned reader.
+func (d *divertReader) divert() *io.PipeReader {
+	r, w := io.Pipe()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.w = w
+	return r
+}
+
+// restore stops diverting the read data.
+func (d *divertReader) restore() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.w != nil {
+		d.w.Close()
+		d.w = nil
+	}
+}
+
+// binarySession is a session in the binary mode of the device.
+type binarySession struct {
+	p        *Port
+	r        *io.PipeReader
+	messages chan binaryMessage
+	done     chan struct{}
+	seq      uint16
+}
+
+// binarySession switches the device to binary mode. It requires that the port is being ranged,
+// such that the responses are read. The session must be closed to return to NMEA mode.
+func (p *Port) binarySession(ctx context.Context) (*binarySession, error) {
+	if p.writer == nil {
+		return nil, fmt.Errorf("port is read-only")
+	}
+	// Configuration requests and other sessions are not allowed during the session.
+	if err := p.lockConfig(ctx); err != nil {
+		return nil, err
+	}
+	p.binary = true
+	p.unlockConfig()
+
+	s := &binarySession{
+		p:        p,
+		r:        p.reader.divert(),
+		messages: make(chan binaryMessage),
+		done:     make(chan struct{}),
+	}
+	go s.read()
+
+	_, err := io.WriteString(p.writer, nmeaSentence("PFLAX")+"\r\n")
+	if err != nil {
+		s.close()
+		return nil, fmt.Errorf("failed entering binary mode: %v", err)
+	}
+	// The device may take time to switch to binary mode, ping it until it answers.
+	for i := 0; ; i++ {
+		_, err = s.request(ctx, binaryPing, nil)
+		if err == nil {
+			return s, nil
+		}
+		if i == binaryPings || ctx.Err() != nil {
+			s.close()
+			return nil, fmt.Errorf("device did not enter binary mode: %v", err)
+		}
+	}
+}
+
+// read reads messages of the session until it is closed.
+func (s *binarySession) read() {
+	defer close(s.messages)
+	r := bufio.NewReader(s.r)
+	for {
+		m, err := readBinaryMessage(r)
+		if errors.Is(err, errBinaryCorrupted) {
+			// Corrupted messages are skipped, and their request times out.
+			continue
+		}
+		if err != nil {
+			return
+		}
+		select {
+		case s.messages <- m:
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// request sends a message and returns the payload of its acknowledgement, without the
+// acknowledged sequence number.
+func (s *binarySession) request(ctx context.Context, typ byte, payload []byte) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, binaryTimeout)
+	defer cancel()
+
+	s.seq++
+	_, err := s.p.writer.Write(binaryMessage{seq: s.seq, typ: typ, payload: payload}.encode())
+	if err != nil {
+		return nil, fmt.Errorf("failed writing binary message: %v", err)
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("no response for binary message %#x: %v", typ, ctx.Err())
+		case m, ok := <-s.messages:
+			if !ok {
+				return nil, fmt.Errorf("connection closed")
+			}
+			if len(m.payload) < 2 || binary.LittleEndian.Uint16(m.payload) != s.seq {
+				// Response of another request.
+				continue
+			}
+			switch m.typ {
+			case binaryACK:
+				return m.payload[2:], nil
+			case binaryNACK:
+				return nil, errBinaryNACK
+			case binaryError:
+				return nil, fmt.Errorf("device error for binary message %#x", typ)
+			}
+		}
+	}
+}
+
+// Close returns the device to NMEA mode.
+func (s *binarySession) Close() error {
+	_, err := s.request(context.Background(), binaryExit, nil)
+	s.close()
+	return err
+}
+
+func (s *binarySession) close() {
+	close(s.done)
+	s.r.Close()
+	s.p.reader.restore()
+	// The semaphore is held only briefly by requests that are rejected during the session.
+	s.p.config <- struct{}{}
+	s.p.binary = false
+	s.p.unlockConfig()
+}
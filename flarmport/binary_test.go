@@ -0,0 +1,70 @@
+package flarmport
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBinaryCRC(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, uint16(0x31C3), binaryCRC([]byte("123456789")))
+	assert.Equal(t, uint16(0x31C3), binaryCRC([]byte("1234"), []byte("56789")))
+}
+
+func TestBinaryFrame(t *testing.T) {
+	t.Parallel()
+
+	// Select record message with sequence number 0x73 and record index 0x78, framed according to
+	// the FLARM binary protocol specification: the start byte is escaped as 0x78 0x31 and the escape
+	// byte as 0x78 0x55.
+	golden := []byte{0x73, 0x09, 0x00, 0x01, 0x78, 0x31, 0x00, 0x20, 0x4D, 0xA1, 0x78, 0x55}
+	m := binaryMessage{seq: 0x73, typ: binarySelectRecord, payload: []byte{0x78}}
+	assert.Equal(t, golden, m.encode())
+
+	got, err := readBinaryMessage(bufio.NewReader(bytes.NewReader(golden)))
+	require.NoError(t, err)
+	assert.Equal(t, m, got)
+
+	// Message types from the specification.
+	assert.Equal(t, 0xA0, binaryACK)
+	assert.Equal(t, 0xB7, binaryNACK)
+	assert.Equal(t, 0x00, binaryError)
+}
+
+func TestBinaryMessage(t *testing.T) {
+	t.Parallel()
+
+	m := binaryMessage{seq: 0x7378, typ: binaryIGCData, payload: []byte{0x01, 0x73, 0x78, 0x02}}
+	frame := m.encode()
+	assert.Equal(t, byte(binaryStartByte), frame[0])
+	// Only the start byte is not escaped.
+	assert.Equal(t, 1, bytes.Count(frame, []byte{binaryStartByte}))
+	assert.Equal(t, []byte{0x01, 0x78, 0x31, 0x78, 0x55, 0x02}, frame[len(frame)-6:])
+
+	// Garbage before the message, corrupted message and a valid message.
+	corrupted := m.encode()
+	corrupted[len(corrupted)-1] ^= 0xFF
+	var stream []byte
+	stream = append(stream, []byte("$PFLAU,0,1,2,1,0,,0,,,*4F\r\n")...)
+	stream = append(stream, corrupted...)
+	stream = append(stream, frame...)
+	stream = append(stream, frame[:5]...)
+	r := bufio.NewReader(bytes.NewReader(stream))
+
+	_, err := readBinaryMessage(r)
+	assert.ErrorIs(t, err, errBinaryCorrupted)
+
+	got, err := readBinaryMessage(r)
+	require.NoError(t, err)
+	assert.Equal(t, m, got)
+
+	// Truncated message.
+	_, err = readBinaryMessage(r)
+	assert.Error(t, err)
+	assert.NotErrorIs(t, err, errBinaryCorrupted)
+}
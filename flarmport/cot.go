@@ -0,0 +1,195 @@
+package flarmport
+
+import (
+	"encoding/xml"
+	"fmt"
+	"log"
+	"time"
+)
+
+const (
+	defaultCoTStale     = 30 * time.Second
+	defaultCoTQueueSize = 256
+	cotTimeFormat       = "2006-01-02T15:04:05.000Z"
+	// cotUnknownError is the circular and linear error value for unknown accuracy.
+	cotUnknownError = 9999999
+	// cotUnknownHAE is the height above ellipsoid value for unknown height. The altitude of the
+	// data is above mean sea level, and the geoid separation is unknown, so the height is sent as
+	// unknown, and the altitude is added to the remarks.
+	cotUnknownHAE = "9999999.0"
+)
+
+// CoTConfig is configuration for sending Cursor-on-Target events to ATAK and similar
+// applications.
+type CoTConfig struct {
+	// UDPAddrs are UDP destination addresses, for example the ATAK situational awareness
+	// multicast address "239.2.3.1:6969".
+	UDPAddrs []string
+	// Addr is the TCP address to serve CoT clients on, e.g. ":8087". If both Addr and UDPAddrs are
+	// empty, CoT is disabled.
+	Addr string
+	// StaleSec is the time in seconds after which an event is considered stale. Default is 30s.
+	StaleSec int
+	// QueueSize is the number of events that are queued for each TCP client. Default is 256.
+	QueueSize int
+}
+
+// CoT sends data as Cursor-on-Target events over UDP, and serves them to TCP clients.
+type CoT struct {
+	udp     *udpSender
+	tcp     *lineServer
+	station StationInfo
+	stale   time.Duration
+}
+
+// cotEvent is a Cursor-on-Target event.
+type cotEvent struct {
+	XMLName xml.Name  `xml:"event"`
+	Version string    `xml:"version,attr"`
+	UID     string    `xml:"uid,attr"`
+	Type    string    `xml:"type,attr"`
+	How     string    `xml:"how,attr"`
+	Time    string    `xml:"time,attr"`
+	Start   string    `xml:"start,attr"`
+	Stale   string    `xml:"stale,attr"`
+	Point   cotPoint  `xml:"point"`
+	Detail  cotDetail `xml:"detail"`
+}
+
+type cotPoint struct {
+	Lat  float64 `xml:"lat,attr"`
+	Long float64 `xml:"lon,attr"`
+	HAE  string  `xml:"hae,attr"`
+	CE   int     `xml:"ce,attr"`
+	LE   int     `xml:"le,attr"`
+}
+
+type cotDetail struct {
+	Contact struct {
+		Callsign string `xml:"callsign,attr"`
+	} `xml:"contact"`
+	Track struct {
+		Course float64 `xml:"course,attr"`
+		Speed  float64 `xml:"speed,attr"`
+	} `xml:"track"`
+	Remarks string `xml:"remarks,omitempty"`
+}
+
+// NewCoT starts sending Cursor-on-Target events. It returns nil if CoT is disabled in the
+// configuration.
+func NewCoT(cfg CoTConfig, station StationInfo) (*CoT, error) {
+	if len(cfg.UDPAddrs) == 0 && cfg.Addr == "" {
+		return nil, nil
+	}
+	if cfg.QueueSize == 0 {
+		cfg.QueueSize = defaultCoTQueueSize
+	}
+	c := &CoT{
+		station: station,
+		stale:   time.Duration(cfg.StaleSec) * time.Second,
+	}
+	if c.stale == 0 {
+		c.stale = defaultCoTStale
+	}
+	if len(cfg.UDPAddrs) > 0 {
+		udp, err := newUDPSender("cot", cfg.UDPAddrs)
+		if err != nil {
+			return nil, err
+		}
+		c.udp = udp
+	}
+	if cfg.Addr != "" {
+		c.tcp = newLineServer("CoT", cfg.QueueSize)
+		err := c.tcp.listen(cfg.Addr)
+		if err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// Data sends an event of the given data. Range-only targets are not sent, since they have no
+// position.
+func (c *CoT) Data(d Data) {
+	if c == nil || d.Kind == KindRangeOnly || d.Address == "" {
+		return
+	}
+	b, err := xml.Marshal(c.event(d, time.Now()))
+	if err != nil {
+		log.Printf("Failed encoding cot event: %v", err)
+		return
+	}
+	if c.udp != nil {
+		c.udp.sendTo(append([]byte(xml.Header), b...))
+	}
+	if c.tcp != nil {
+		c.tcp.send(string(b))
+	}
+}
+
+// Close stops sending events.
+func (c *CoT) Close() error {
+	if c == nil {
+		return nil
+	}
+	var err error
+	if c.udp != nil {
+		err = c.udp.close()
+	}
+	if c.tcp != nil {
+		if err2 := c.tcp.close(); err == nil {
+			err = err2
+		}
+	}
+	return err
+}
+
+// event returns the event of the given data.
+func (c *CoT) event(d Data, now time.Time) cotEvent {
+	t := d.Time
+	if t.IsZero() {
+		t = now
+	}
+	e := cotEvent{
+		Version: "2.0",
+		UID:     "flarm-" + d.Address,
+		Type:    cotType(d.Type),
+		How:     "m-g",
+		Time:    now.UTC().Format(cotTimeFormat),
+		Start:   t.UTC().Format(cotTimeFormat),
+		Stale:   t.Add(c.stale).UTC().Format(cotTimeFormat),
+		Point: cotPoint{
+			Lat:  d.Lat,
+			Long: d.Long,
+			HAE:  cotUnknownHAE,
+			CE:   cotUnknownError,
+			LE:   cotUnknownError,
+		},
+	}
+	e.Detail.Contact.Callsign = c.station.MapID(d.Address)
+	e.Detail.Track.Course = float64(d.Dir)
+	e.Detail.Track.Speed = float64(d.GroundSpeed)
+	e.Detail.Remarks = fmt.Sprintf("%s, altitude %.0fm MSL", d.Type, d.Alt)
+	return e
+}
+
+// cotType returns the CoT type of an aircraft type returned by aircraftType. All aircraft are
+// neutral civilian.
+func cotType(tp string) string {
+	switch aircraftTypeCode(tp) {
+	case "1", "2", "5", "6", "7", "8", "9":
+		return "a-n-A-C-F" // Fixed wing.
+	case "3":
+		return "a-n-A-C-H" // Rotary wing.
+	case "B", "C":
+		return "a-n-A-C-L" // Lighter than air.
+	case "D":
+		return "a-n-A-C-F-q" // Drone.
+	case "4":
+		return "a-n-G-U-C-I" // Skydiver, shown as a ground person.
+	case "F":
+		return "a-n-G-I" // Static object.
+	}
+	return "a-n-A-C" // Civilian aircraft.
+}
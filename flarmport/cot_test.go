@@ -0,0 +1,118 @@
+package flarmport
+
+import (
+	"bufio"
+	"encoding/xml"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCoTEvent(t *testing.T) {
+	t.Parallel()
+
+	c := &CoT{
+		station: StationInfo{IDMap: map[string]Aircraft{"DD8E8B": {Name: "APL", Registration: "4X-APL"}}},
+		stale:   defaultCoTStale,
+	}
+	now := time.Date(2020, 12, 17, 12, 35, 37, 0, time.UTC)
+	d := Data{
+		Kind:        KindPosition,
+		Address:     "DD8E8B",
+		Lat:         32.5,
+		Long:        35.2,
+		Alt:         1100,
+		Dir:         90,
+		GroundSpeed: 30,
+		Type:        "glider",
+		Time:        time.Date(2020, 12, 17, 14, 35, 36, 0, time.FixedZone("IST", 2*60*60)),
+	}
+	b, err := xml.Marshal(c.event(d, now))
+	require.NoError(t, err)
+	assert.Equal(t,
+		`<event version="2.0" uid="flarm-DD8E8B" type="a-n-A-C-F" how="m-g" `+
+			`time="2020-12-17T12:35:37.000Z" start="2020-12-17T12:35:36.000Z" stale="2020-12-17T12:36:06.000Z">`+
+			`<point lat="32.5" lon="35.2" hae="9999999.0" ce="9999999" le="9999999"></point>`+
+			`<detail><contact callsign="APL"></contact><track course="90" speed="30"></track>`+
+			`<remarks>glider, altitude 1100m MSL</remarks></detail></event>`,
+		string(b))
+
+	// Unmapped aircraft without time.
+	e := c.event(Data{Address: "AAAAAA", Type: "helicopter / rotorcraft"}, now)
+	assert.Equal(t, "AAAAAA", e.Detail.Contact.Callsign)
+	assert.Equal(t, "a-n-A-C-H", e.Type)
+	assert.Equal(t, "2020-12-17T12:35:37.000Z", e.Start)
+}
+
+func TestCoTType(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]string{
+		"glider":                        "a-n-A-C-F",
+		"towplane":                      "a-n-A-C-F",
+		"helicopter / rotorcraft":       "a-n-A-C-H",
+		"balloon":                       "a-n-A-C-L",
+		"unmanned aerial vehicle (UAV)": "a-n-A-C-F-q",
+		"skydiver":                      "a-n-G-U-C-I",
+		"static object":                 "a-n-G-I",
+		"unknown":                       "a-n-A-C",
+	}
+	for tp, want := range tests {
+		assert.Equal(t, want, cotType(tp), tp)
+	}
+}
+
+func TestCoT(t *testing.T) {
+	t.Parallel()
+
+	udp, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer udp.Close()
+
+	c, err := NewCoT(CoTConfig{UDPAddrs: []string{udp.LocalAddr().String()}, Addr: "127.0.0.1:0"}, StationInfo{})
+	require.NoError(t, err)
+	defer c.Close()
+
+	conn, err := net.Dial("tcp", c.tcp.listener.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	require.Eventually(t, func() bool {
+		c.tcp.mu.Lock()
+		defer c.tcp.mu.Unlock()
+		return len(c.tcp.clients) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	c.Data(Data{Kind: KindRangeOnly, Distance: 100}) // Not sent.
+	c.Data(Data{Kind: KindPosition, Address: "DD8E8B", Lat: 32.5, Long: 35.2})
+
+	var e cotEvent
+
+	buf := make([]byte, 4096)
+	udp.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := udp.ReadFrom(buf)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(buf[:n]), "<?xml"))
+	require.NoError(t, xml.Unmarshal(buf[:n], &e))
+	assert.Equal(t, "flarm-DD8E8B", e.UID)
+	assert.Equal(t, 32.5, e.Point.Lat)
+
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	require.NoError(t, err)
+	require.NoError(t, xml.Unmarshal([]byte(line), &e))
+	assert.Equal(t, "flarm-DD8E8B", e.UID)
+	assert.Equal(t, 35.2, e.Point.Long)
+}
+
+func TestCoTDisabled(t *testing.T) {
+	t.Parallel()
+
+	c, err := NewCoT(CoTConfig{}, StationInfo{})
+	require.NoError(t, err)
+	assert.Nil(t, c)
+	c.Data(Data{})
+	assert.NoError(t, c.Close())
+}
@@ -0,0 +1,140 @@
+package flarmport
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"time"
+)
+
+// DeviceStatus is the identity and health of a FLARM device, as reported in the PFLAV and PFLAE
+// sentences.
+type DeviceStatus struct {
+	HardwareVersion string
+	SoftwareVersion string
+	// ObstacleVersion is the version of the obstacle database.
+	ObstacleVersion string
+	// Severity of the last self-test result: "no error", "information", "reduced functionality" or
+	// "fatal". Empty if no result was reported.
+	Severity string
+	// ErrorCode is the hexadecimal code of the last reported error, and Error is its description.
+	ErrorCode string
+	Error     string
+	// Message is an optional error message of the device.
+	Message string
+	// Updated is the time of the last report.
+	Updated time.Time
+}
+
+// DeviceStatus returns the last reported device status.
+func (p *Port) DeviceStatus() DeviceStatus {
+	p.statusMu.Lock()
+	defer p.statusMu.Unlock()
+	return p.deviceStatus
+}
+
+// QueryDeviceStatus requests the device to report its version and self-test result. The reports
+// are read while the port is being ranged.
+func (p *Port) QueryDeviceStatus() error {
+	if p.writer == nil {
+		return fmt.Errorf("port is read-only")
+	}
+	if err := p.lockConfig(context.Background()); err != nil {
+		return err
+	}
+	defer p.unlockConfig()
+	_, err := io.WriteString(p.writer, nmeaSentence("PFLAV", "R")+"\r\n"+nmeaSentence("PFLAE", "R")+"\r\n")
+	return err
+}
+
+// updatePFLAV updates the device status with a version report.
+func (p *Port) updatePFLAV(e TypePFLAV) {
+	if e.QueryType == "R" {
+		return
+	}
+	p.statusMu.Lock()
+	defer p.statusMu.Unlock()
+	p.deviceStatus.HardwareVersion = e.HwVersion
+	p.deviceStatus.SoftwareVersion = e.SwVersion
+	p.deviceStatus.ObstacleVersion = e.ObstVersion
+	p.deviceStatus.Updated = time.Now()
+}
+
+// updatePFLAE updates the device status with a self-test result.
+func (p *Port) updatePFLAE(e TypePFLAE) {
+	if e.QueryType == "R" {
+		return
+	}
+	p.statusMu.Lock()
+	defer p.statusMu.Unlock()
+	s := &p.deviceStatus
+	if e.Severity != "no error" && (e.Severity != s.Severity || e.ErrorCode != s.ErrorCode) {
+		log.Printf("Flarm device reported %s: %s (%s) %s", e.Severity, errorCode(e.ErrorCode), e.ErrorCode, e.Message)
+	}
+	s.Severity = e.Severity
+	s.ErrorCode = e.ErrorCode
+	s.Error = errorCode(e.ErrorCode)
+	s.Message = e.Message
+	s.Updated = time.Now()
+}
+
+// Device is the currently connected flarm port, that can be configured. It is safe for concurrent
+// use.
+type Device struct {
+	mu   sync.Mutex
+	port *Port
+}
+
+// Set sets the currently connected port.
+func (d *Device) Set(p *Port) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.port = p
+}
+
+// DeviceStatus returns the last reported status of the connected device.
+func (d *Device) DeviceStatus() (DeviceStatus, error) {
+	p, err := d.get()
+	if err != nil {
+		return DeviceStatus{}, err
+	}
+	return p.DeviceStatus(), nil
+}
+
+// DeviceConfig reads the configuration of the connected device.
+func (d *Device) DeviceConfig(ctx context.Context) (DeviceConfig, error) {
+	p, err := d.get()
+	if err != nil {
+		return DeviceConfig{}, err
+	}
+	return p.DeviceConfig(ctx)
+}
+
+// SetConfig sets a configuration value of the connected device.
+func (d *Device) SetConfig(ctx context.Context, key, value string) (string, error) {
+	p, err := d.get()
+	if err != nil {
+		return "", err
+	}
+	return p.SetConfig(ctx, key, value)
+}
+
+// DownloadIGC downloads the flights of the connected device into IGC files in the given directory.
+func (d *Device) DownloadIGC(ctx context.Context, dir string) ([]string, error) {
+	p, err := d.get()
+	if err != nil {
+		return nil, err
+	}
+	return p.DownloadIGC(ctx, dir)
+}
+
+func (d *Device) get() (*Port, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.port == nil {
+		return nil, fmt.Errorf("flarm port is not connected")
+	}
+	return d.port, nil
+}
@@ -0,0 +1,106 @@
+package flarmport
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/adrianmo/go-nmea"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPFLAVAndPFLAE(t *testing.T) {
+	t.Parallel()
+
+	v, err := nmea.Parse("$PFLAV,A,2.00,6.00,2021.1*16")
+	require.NoError(t, err)
+	pflav := v.(TypePFLAV)
+	assert.Equal(t, "A", pflav.QueryType)
+	assert.Equal(t, "2.00", pflav.HwVersion)
+	assert.Equal(t, "6.00", pflav.SwVersion)
+	assert.Equal(t, "2021.1", pflav.ObstVersion)
+
+	v, err = nmea.Parse("$PFLAE,A,3,11,Firmware expired*4A")
+	require.NoError(t, err)
+	pflae := v.(TypePFLAE)
+	assert.Equal(t, "fatal", pflae.Severity)
+	assert.Equal(t, "11", pflae.ErrorCode)
+	assert.Equal(t, "Firmware expired", pflae.Message)
+
+	// Requests.
+	v, err = nmea.Parse(nmeaSentence("PFLAE", "R"))
+	require.NoError(t, err)
+	assert.Equal(t, "R", v.(TypePFLAE).QueryType)
+	v, err = nmea.Parse(nmeaSentence("PFLAV", "R"))
+	require.NoError(t, err)
+	assert.Equal(t, "R", v.(TypePFLAV).QueryType)
+}
+
+func TestPortDeviceStatus(t *testing.T) {
+	t.Parallel()
+
+	lines := []string{
+		"$PFLAV,A,2.00,6.00,2021.1*16",
+		"$PFLAE,A,2,33*01",
+		"$PFLAE,A,3,11,Firmware expired*4A",
+		"$PFLAE,A,0,0*33",
+	}
+	p := newPort(io.NopCloser(strings.NewReader(strings.Join(lines, "\r\n"))), StationInfo{})
+	assert.Equal(t, DeviceStatus{}, p.DeviceStatus())
+
+	p.next()
+	got := p.DeviceStatus()
+	assert.Equal(t, "2.00", got.HardwareVersion)
+	assert.Equal(t, "6.00", got.SoftwareVersion)
+	assert.Equal(t, "2021.1", got.ObstacleVersion)
+	assert.False(t, got.Updated.IsZero())
+
+	p.next()
+	got = p.DeviceStatus()
+	assert.Equal(t, "reduced functionality", got.Severity)
+	assert.Equal(t, "33", got.ErrorCode)
+	assert.Equal(t, "GPS antenna", got.Error)
+	assert.Equal(t, "6.00", got.SoftwareVersion)
+
+	p.next()
+	got = p.DeviceStatus()
+	assert.Equal(t, "fatal", got.Severity)
+	assert.Equal(t, "firmware expired", got.Error)
+	assert.Equal(t, "Firmware expired", got.Message)
+
+	p.next()
+	got = p.DeviceStatus()
+	assert.Equal(t, "no error", got.Severity)
+	assert.Equal(t, "", got.Error)
+	assert.Equal(t, "", got.Message)
+
+	// Querying a read-only port.
+	assert.Error(t, p.QueryDeviceStatus())
+}
+
+func TestQueryDeviceStatus(t *testing.T) {
+	t.Parallel()
+
+	conn, device := net.Pipe()
+	defer device.Close()
+	p := newPort(conn, StationInfo{})
+	defer p.Close()
+
+	go p.QueryDeviceStatus()
+	r := bufio.NewReader(device)
+	for _, want := range []string{"$PFLAV,R*", "$PFLAE,R*"} {
+		line, err := r.ReadString('\n')
+		require.NoError(t, err)
+		assert.True(t, strings.HasPrefix(line, want), line)
+	}
+
+	var d Device
+	_, err := d.DeviceStatus()
+	assert.Error(t, err)
+	d.Set(p)
+	_, err = d.DeviceStatus()
+	assert.NoError(t, err)
+}
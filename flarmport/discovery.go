@@ -0,0 +1,221 @@
+package flarmport
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultProbeTimeout = 3 * time.Second
+	// probeSentences is the number of valid sentences that identify a FLARM device, of which at
+	// least one is a FLARM sentence.
+	probeSentences = 2
+)
+
+var (
+	// DefaultDiscoveryPatterns are the default glob patterns of candidate serial devices: USB
+	// serial adapters and the Raspberry Pi UART.
+	DefaultDiscoveryPatterns = []string{"/dev/ttyUSB*", "/dev/ttyACM*", "/dev/ttyAMA*", "/dev/serial0"}
+	// DefaultBaudRates are the default baud rates to try, starting with the FLARM default.
+	DefaultBaudRates = []uint{19200, 57600, 115200, 38400, 9600, 4800}
+)
+
+// DiscoveryConfig is the configuration for discovering the serial port of a FLARM device.
+type DiscoveryConfig struct {
+	// Patterns are glob patterns of candidate serial devices. Default is
+	// DefaultDiscoveryPatterns.
+	Patterns []string
+	// Exclude are serial devices that are not probed, such as ports that are used for output.
+	Exclude []string
+	// BaudRates to try, in order. Default is DefaultBaudRates.
+	BaudRates []uint
+	// ProbeTimeoutSec is the time to wait for valid sentences on each device and baud rate.
+	// Default is 3s.
+	ProbeTimeoutSec int
+}
+
+// Discovery discovers the serial port and baud rate of a FLARM device, by probing the candidate
+// devices in each of the baud rates until valid checksummed sentences, including a FLARM sentence,
+// are read, such that other NMEA devices such as GPS receivers are not mistaken for it. The last
+// discovered port is probed first, such that reconnecting to the same device is fast, while a
+// device that was re-enumerated under a different name is still found. It is safe for concurrent
+// use.
+type Discovery struct {
+	cfg DiscoveryConfig
+	// open opens a serial port, and can be replaced for testing.
+	open func(path string, baudRate uint) (io.ReadWriteCloser, error)
+
+	mu       sync.Mutex
+	path     string
+	baudRate uint
+}
+
+// NewDiscovery returns a discovery of FLARM serial ports.
+func NewDiscovery(cfg DiscoveryConfig) *Discovery {
+	if len(cfg.Patterns) == 0 {
+		cfg.Patterns = DefaultDiscoveryPatterns
+	}
+	if len(cfg.BaudRates) == 0 {
+		cfg.BaudRates = DefaultBaudRates
+	}
+	if cfg.ProbeTimeoutSec <= 0 {
+		cfg.ProbeTimeoutSec = int(defaultProbeTimeout / time.Second)
+	}
+	return &Discovery{cfg: cfg, open: openSerial}
+}
+
+// Open discovers the FLARM serial port and opens it.
+func (d *Discovery) Open(ctx context.Context, station StationInfo) (*Port, error) {
+	conn, err := d.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return newPort(conn, station), nil
+}
+
+// discover returns an open connection to the FLARM serial port.
+func (d *Discovery) discover(ctx context.Context) (io.ReadWriteCloser, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	timeout := time.Duration(d.cfg.ProbeTimeoutSec) * time.Second
+	for _, path := range d.candidates() {
+		for _, baudRate := range d.baudRates(path) {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			conn, err := d.open(path, baudRate)
+			if err != nil {
+				// The device may be in use or not a serial port, try the next one.
+				log.Printf("Failed probing %s: %v", path, err)
+				break
+			}
+			if !probe(ctx, conn, timeout) {
+				continue
+			}
+			if path != d.path || baudRate != d.baudRate {
+				log.Printf("Discovered flarm on %s at %d baud", path, baudRate)
+			}
+			d.path, d.baudRate = path, baudRate
+			return conn, nil
+		}
+	}
+	return nil, fmt.Errorf("no flarm device found in %s", strings.Join(d.cfg.Patterns, ", "))
+}
+
+// candidates returns the candidate serial devices, starting with the last discovered device.
+func (d *Discovery) candidates() []string {
+	exclude := make(map[string]bool)
+	for _, path := range d.cfg.Exclude {
+		exclude[resolve(path)] = true
+	}
+	var paths []string
+	seen := make(map[string]bool)
+	add := func(path string) {
+		// Symbolic links to the same device are probed once.
+		r := resolve(path)
+		if seen[r] || exclude[r] {
+			return
+		}
+		seen[r] = true
+		paths = append(paths, path)
+	}
+
+	var matches []string
+	for _, pattern := range d.cfg.Patterns {
+		m, err := filepath.Glob(pattern)
+		if err != nil {
+			log.Printf("Invalid discovery pattern %q: %v", pattern, err)
+			continue
+		}
+		matches = append(matches, m...)
+	}
+	sort.Strings(matches)
+	for _, path := range matches {
+		if path == d.path {
+			add(path)
+		}
+	}
+	for _, path := range matches {
+		add(path)
+	}
+	return paths
+}
+
+// baudRates returns the baud rates to probe a device with, starting with the last discovered baud
+// rate of the device.
+func (d *Discovery) baudRates(path string) []uint {
+	if path != d.path {
+		return d.cfg.BaudRates
+	}
+	rates := []uint{d.baudRate}
+	for _, r := range d.cfg.BaudRates {
+		if r != d.baudRate {
+			rates = append(rates, r)
+		}
+	}
+	return rates
+}
+
+// resolve returns the path that a symbolic link points to, or the path itself.
+func resolve(path string) string {
+	r, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return path
+	}
+	return r
+}
+
+// probe returns whether valid checksummed NMEA sentences, including a FLARM sentence, are read
+// from the connection within the timeout. The connection is closed if the probe fails.
+func probe(ctx context.Context, conn io.ReadCloser, timeout time.Duration) bool {
+	result := make(chan bool, 1)
+	go func() {
+		s := bufio.NewScanner(conn)
+		s.Split(splitCR)
+		valid, flarm := 0, false
+		for s.Scan() {
+			line := s.Text()
+			if !validSentence(line) {
+				continue
+			}
+			valid++
+			// PFLAU is sent by the device every second.
+			flarm = flarm || strings.HasPrefix(strings.TrimSpace(line), "$PFLA")
+			if valid >= probeSentences && flarm {
+				result <- true
+				return
+			}
+		}
+		result <- false
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case ok := <-result:
+		if !ok {
+			conn.Close()
+		}
+		return ok
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+	// Closing the connection stops the reading goroutine.
+	conn.Close()
+	return false
+}
+
+// validSentence returns whether the line is an NMEA sentence with a valid checksum.
+func validSentence(line string) bool {
+	_, _, ok := sentenceFields(line)
+	return ok
+}
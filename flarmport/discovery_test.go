@@ -0,0 +1,143 @@
+package flarmport
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiscovery(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	for _, name := range []string{"ttyACM0", "ttyUSB0", "ttyUSB1", "other"} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), nil, 0644))
+	}
+	// A link to a device is probed once.
+	require.NoError(t, os.Symlink(filepath.Join(dir, "ttyUSB1"), filepath.Join(dir, "ttyUSB9")))
+
+	// A GPS receiver is connected to ttyACM0.
+	device := fakeSerial{path: filepath.Join(dir, "ttyUSB1"), baudRate: 57600, gps: filepath.Join(dir, "ttyACM0")}
+	d := NewDiscovery(DiscoveryConfig{
+		Patterns:  []string{filepath.Join(dir, "ttyACM*"), filepath.Join(dir, "ttyUSB*")},
+		BaudRates: []uint{19200, 57600},
+	})
+	d.open = device.open
+
+	p, err := d.Open(context.Background(), StationInfo{})
+	require.NoError(t, err)
+	// The port reads from the discovered device.
+	_, ok := p.next()
+	assert.True(t, ok)
+	assert.Equal(t, []string{
+		filepath.Join(dir, "ttyACM0") + "@19200",
+		filepath.Join(dir, "ttyACM0") + "@57600",
+		filepath.Join(dir, "ttyUSB0") + "@19200",
+		filepath.Join(dir, "ttyUSB0") + "@57600",
+		filepath.Join(dir, "ttyUSB1") + "@19200",
+		filepath.Join(dir, "ttyUSB1") + "@57600",
+	}, device.probed())
+
+	// On reconnect, the last discovered device is probed first.
+	device.reset()
+	_, err = d.Open(context.Background(), StationInfo{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{filepath.Join(dir, "ttyUSB1") + "@57600"}, device.probed())
+
+	// The device is re-enumerated under a different name.
+	require.NoError(t, os.Remove(filepath.Join(dir, "ttyUSB9")))
+	require.NoError(t, os.Rename(filepath.Join(dir, "ttyUSB1"), filepath.Join(dir, "ttyUSB2")))
+	device.reset()
+	device.path = filepath.Join(dir, "ttyUSB2")
+	_, err = d.Open(context.Background(), StationInfo{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		filepath.Join(dir, "ttyACM0") + "@19200",
+		filepath.Join(dir, "ttyACM0") + "@57600",
+		filepath.Join(dir, "ttyUSB0") + "@19200",
+		filepath.Join(dir, "ttyUSB0") + "@57600",
+		filepath.Join(dir, "ttyUSB2") + "@19200",
+		filepath.Join(dir, "ttyUSB2") + "@57600",
+	}, device.probed())
+}
+
+func TestDiscoveryNotFound(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "ttyUSB0"), nil, 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "ttyUSB1"), nil, 0644))
+
+	device := fakeSerial{path: filepath.Join(dir, "ttyUSB1"), baudRate: 57600}
+	d := NewDiscovery(DiscoveryConfig{
+		Patterns: []string{filepath.Join(dir, "ttyUSB*")},
+		Exclude:  []string{filepath.Join(dir, "ttyUSB1")},
+	})
+	d.open = device.open
+
+	_, err := d.Open(context.Background(), StationInfo{})
+	assert.Error(t, err)
+	assert.Len(t, device.probed(), len(DefaultBaudRates))
+}
+
+func TestValidSentence(t *testing.T) {
+	t.Parallel()
+
+	assert.True(t, validSentence("$PFLAU,3,1,2,1,0,,0,,,*4F"))
+	// Valid checksum of an unknown sentence.
+	assert.True(t, validSentence("\n$PFLAJ,A,1,0*3D"))
+	assert.False(t, validSentence("$PFLAU,3,1,2,1,0,,0,,,*00"))
+	assert.False(t, validSentence("\x8c\xfe$PF"))
+	assert.False(t, validSentence(""))
+}
+
+// fakeSerial simulates serial devices, where a FLARM device is connected to one of them, and a GPS
+// receiver is optionally connected to another one in all baud rates.
+type fakeSerial struct {
+	path     string
+	baudRate uint
+	gps      string
+
+	mu     sync.Mutex
+	probes []string
+}
+
+func (f *fakeSerial) open(path string, baudRate uint) (io.ReadWriteCloser, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.probes = append(f.probes, fmt.Sprintf("%s@%d", path, baudRate))
+	data := "\x8c\xfe\x13\x8c\r\xf0\xfe"
+	switch {
+	case path == f.path && baudRate == f.baudRate:
+		data = strings.Repeat("$PFLAU,3,1,2,1,0,,0,,,*4F\r\n", 500)
+	case path == f.gps:
+		data = strings.Repeat("$GPGSV,1,1,00*79\r\n", 10)
+	}
+	return nopWriter{strings.NewReader(data)}, nil
+}
+
+func (f *fakeSerial) probed() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.probes
+}
+
+func (f *fakeSerial) reset() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.probes = nil
+}
+
+type nopWriter struct{ *strings.Reader }
+
+func (nopWriter) Write(b []byte) (int, error) { return len(b), nil }
+
+func (nopWriter) Close() error { return nil }
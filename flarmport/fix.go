@@ -0,0 +1,138 @@
+package flarmport
+
+import (
+	"strings"
+	"time"
+
+	"github.com/adrianmo/go-nmea"
+)
+
+// fixTimeout is the time after which a GPS fix is considered stale. GPS sentences without a fix
+// might have empty coordinates that fail parsing, so a fix that was not updated is dropped.
+const fixTimeout = 10 * time.Second
+
+// fix is the last known GPS fix of the flarm receiver itself, as reported in the GPRMC and GPGGA
+// sentences. It enables using the flarm on a moving station, such as a towplane or a car.
+type fix struct {
+	// valid is true if the last received GPS sentence had a valid fix.
+	valid bool
+	// Latitude and longitude coordinates of the receiver.
+	lat, long float64
+	// Altitude above mean sea level of the receiver, in meters. Only set by GPGGA, in which case
+	// hasAlt is true.
+	alt    float64
+	hasAlt bool
+	// updated is the time of the last valid fix.
+	updated time.Time
+	// utc is the last GPS time, and utcUpdated is the wall clock time in which it was received.
+	utc        time.Time
+	utcUpdated time.Time
+}
+
+// updateRMC updates the fix from a GPRMC sentence.
+func (f *fix) updateRMC(e nmea.RMC) {
+	// GPS time might be valid even without a position fix.
+	f.updateTime(e.Time, e.Date)
+	f.valid = e.Validity == nmea.ValidRMC
+	if f.valid {
+		f.lat, f.long = e.Latitude, e.Longitude
+		f.updated = time.Now()
+	}
+}
+
+// updateGGA updates the fix from a GPGGA sentence.
+func (f *fix) updateGGA(e nmea.GGA) {
+	f.valid = e.FixQuality != "" && e.FixQuality != nmea.Invalid
+	if f.valid {
+		f.lat, f.long = e.Latitude, e.Longitude
+		f.alt, f.hasAlt = e.Altitude, true
+		f.updated = time.Now()
+	}
+}
+
+// updateRaw updates the fix from the raw fields of a GPRMC or GPGGA sentence that failed parsing,
+// such as a sentence without a position fix that has malformed coordinates. The GPS time is taken
+// from a GPRMC sentence, and the position fix is invalidated. It returns false if the line is not
+// a GPRMC or GPGGA sentence with a valid checksum.
+func (f *fix) updateRaw(line string) bool {
+	prefix, fields, ok := sentenceFields(line)
+	if !ok {
+		return false
+	}
+	switch sentenceType(prefix) {
+	case nmea.TypeRMC:
+		// Fields: time, validity, latitude, N/S, longitude, E/W, speed, course, date, ...
+		if len(fields) > 8 {
+			t, errT := nmea.ParseTime(fields[0])
+			d, errD := nmea.ParseDate(fields[8])
+			if errT == nil && errD == nil {
+				f.updateTime(t, d)
+			}
+		}
+	case nmea.TypeGGA:
+	default:
+		return false
+	}
+	f.valid = false
+	return true
+}
+
+// updateTime updates the GPS time, if valid.
+func (f *fix) updateTime(t nmea.Time, d nmea.Date) {
+	if !t.Valid || !d.Valid {
+		return
+	}
+	f.utc = time.Date(2000+d.YY, time.Month(d.MM), d.DD,
+		t.Hour, t.Minute, t.Second, t.Millisecond*int(time.Millisecond), time.UTC)
+	f.utcUpdated = time.Now()
+}
+
+// sentenceFields returns the prefix, such as "GPRMC", and the fields of an NMEA sentence. It
+// returns false if the line is not an NMEA sentence with a valid checksum.
+func sentenceFields(line string) (prefix string, fields []string, ok bool) {
+	line = strings.TrimSpace(line)
+	if !strings.HasPrefix(line, nmea.SentenceStart) {
+		return "", nil, false
+	}
+	i := strings.LastIndex(line, nmea.ChecksumSep)
+	if i < 0 || !strings.EqualFold(line[i+1:], nmea.Checksum(line[1:i])) {
+		return "", nil, false
+	}
+	fields = strings.Split(line[1:i], nmea.FieldSep)
+	return fields[0], fields[1:], true
+}
+
+// sentenceType returns the type of a sentence prefix, without the talker ID, such as "RMC" for
+// "GPRMC" or "FLAU" for the proprietary "PFLAU".
+func sentenceType(prefix string) string {
+	if strings.HasPrefix(prefix, "P") {
+		return prefix[1:]
+	}
+	if len(prefix) < 2 {
+		return ""
+	}
+	return prefix[2:]
+}
+
+// location returns the location of the receiver. If there is no valid fix, it falls back to the
+// configured station location.
+func (f *fix) location(s StationInfo) (lat, long, alt float64) {
+	if !f.valid || time.Since(f.updated) > fixTimeout {
+		return s.Lat, s.Long, s.Alt
+	}
+	alt = s.Alt
+	if f.hasAlt {
+		alt = f.alt
+	}
+	return f.lat, f.long, alt
+}
+
+// time returns the current GPS time, extrapolated from the last GPS time by the time that passed
+// since it was received, or zero time if it is unknown.
+func (f *fix) time() time.Time {
+	elapsed := time.Since(f.utcUpdated)
+	if f.utcUpdated.IsZero() || elapsed > fixTimeout {
+		return time.Time{}
+	}
+	return f.utc.Add(elapsed)
+}
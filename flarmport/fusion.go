@@ -0,0 +1,264 @@
+package flarmport
+
+import (
+	"context"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultFusionWindow         = 5 * time.Second
+	defaultFusionReconnectDelay = 3 * time.Second
+	// fusionTimeTolerance is the time difference below which reports of different sources are
+	// considered simultaneous. Some sources report times in a resolution of a second, and the
+	// clocks of sources may be slightly skewed.
+	fusionTimeTolerance = time.Second
+)
+
+// Source is an input source of a Fusion.
+type Source struct {
+	// Name of the source, recorded in the data that it reports.
+	Name string
+	// Open opens a reader for the source. It is called again to reconnect after the reader stops.
+	Open func() (Reader, error)
+}
+
+// FusionConfig is configuration for fusing multiple sources.
+type FusionConfig struct {
+	// WindowSec is the time window in seconds in which reports of the same aircraft from different
+	// sources are considered duplicates. Default is 5s.
+	WindowSec int
+	// ReconnectDelaySec is the delay in seconds before reopening a source that stopped. Default is
+	// 3s.
+	ReconnectDelaySec int
+}
+
+// Fusion reads from multiple sources simultaneously, and deduplicates aircraft that are reported
+// by more than one source. It reconnects each of the sources independently. The handler functions
+// are called concurrently from the different sources.
+type Fusion struct {
+	sources        []Source
+	window         time.Duration
+	reconnectDelay time.Duration
+
+	mu      sync.Mutex
+	tracks  map[string]*track
+	pruned  time.Time
+	readers map[Reader]bool
+	closed  bool
+}
+
+// track is the last reported data of an aircraft.
+type track struct {
+	last Data
+	// seen holds the last time that each of the sources reported the aircraft.
+	seen map[string]time.Time
+}
+
+// Fuse returns a reader that fuses the given sources.
+func Fuse(cfg FusionConfig, sources ...Source) *Fusion {
+	f := &Fusion{
+		sources:        sources,
+		window:         time.Duration(cfg.WindowSec) * time.Second,
+		reconnectDelay: time.Duration(cfg.ReconnectDelaySec) * time.Second,
+		tracks:         map[string]*track{},
+		readers:        map[Reader]bool{},
+	}
+	if f.window == 0 {
+		f.window = defaultFusionWindow
+	}
+	if f.reconnectDelay == 0 {
+		f.reconnectDelay = defaultFusionReconnectDelay
+	}
+	return f
+}
+
+// Range iterates over the positions of other aircraft. See RangeHandler for reading all values.
+func (f *Fusion) Range(ctx context.Context, data func(Data)) error {
+	return f.RangeHandler(ctx, Handler{Data: data})
+}
+
+// RangeHandler reads from all sources until the context is cancelled.
+func (f *Fusion) RangeHandler(ctx context.Context, h Handler) error {
+	var wg sync.WaitGroup
+	for _, s := range f.sources {
+		wg.Add(1)
+		go func(s Source) {
+			defer wg.Done()
+			f.run(ctx, s, h)
+		}(s)
+	}
+	<-ctx.Done()
+	f.Close()
+	wg.Wait()
+	return ctx.Err()
+}
+
+// Close closes all the open readers.
+func (f *Fusion) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.closed = true
+	for r := range f.readers {
+		r.Close()
+	}
+	return nil
+}
+
+// run reads from a single source, and reopens it when it stops.
+func (f *Fusion) run(ctx context.Context, s Source, h Handler) {
+	for ctx.Err() == nil {
+		r, err := s.Open()
+		if err == nil && f.add(r) {
+			log.Printf("Start reading from %s...", s.Name)
+			err = rangeHandler(ctx, r, Handler{
+				Data:   func(d Data) { f.data(s.Name, d, h) },
+				Status: func(st Status) { f.status(st, h) },
+			})
+			f.remove(r)
+		}
+		if err != nil {
+			log.Printf("Failed reading from %s: %v", s.Name, err)
+		}
+		if ctx.Err() == nil {
+			log.Printf("Will try to reconnect to %s in %v...", s.Name, f.reconnectDelay)
+			select {
+			case <-ctx.Done():
+			case <-time.After(f.reconnectDelay):
+			}
+		}
+	}
+}
+
+// add registers an open reader. If the fusion was closed, it closes the reader and returns false.
+func (f *Fusion) add(r Reader) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		r.Close()
+		return false
+	}
+	f.readers[r] = true
+	return true
+}
+
+func (f *Fusion) remove(r Reader) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.readers, r)
+	r.Close()
+}
+
+func (f *Fusion) status(s Status, h Handler) {
+	h.handle(&s)
+}
+
+// data handles data reported by a source. The data is passed to the handler only if it is fresher
+// or of better quality than the last data of the same aircraft.
+func (f *Fusion) data(source string, d Data, h Handler) {
+	// The handler is called without holding the lock, such that a slow handler does not block
+	// the other sources.
+	if f.merge(source, &d) {
+		h.handle(&d)
+	}
+}
+
+// merge updates the track of the aircraft with the data, and returns whether the data should be
+// passed to the handler.
+func (f *Fusion) merge(source string, d *Data) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	now := time.Now()
+	f.prune(now)
+
+	d.Source = source
+	key, ok := trackKey(*d)
+	if !ok {
+		// The aircraft can't be identified, and is never deduplicated.
+		d.SeenBy = source
+		return true
+	}
+	t := f.tracks[key]
+	isNew := t == nil || d.Time.Sub(t.last.Time) > f.window
+	if isNew {
+		t = &track{seen: map[string]time.Time{}}
+		f.tracks[key] = t
+	}
+	t.seen[source] = now
+
+	if !isNew && !t.update(*d) {
+		return false
+	}
+	d.SeenBy = t.seenBy(now, f.window)
+	t.last = *d
+	return true
+}
+
+// trackKey returns the key that identifies the aircraft of the data, or false if it can't be
+// identified. Range-only targets are identified only by their address, since they have a generic
+// name, such as "Mode-C", and they are tracked separately from positions of the same address.
+func trackKey(d Data) (string, bool) {
+	if d.Kind == KindRangeOnly {
+		if d.Address == "" {
+			return "", false
+		}
+		return KindRangeOnly + " " + d.Address, true
+	}
+	if d.Address != "" {
+		return d.Address, true
+	}
+	return d.Name, d.Name != ""
+}
+
+// update returns whether the data should replace the last data of the track. Data that is fresher
+// by more than the time tolerance replaces it. Within the time tolerance, where the reports of
+// different sources can't be ordered by their time, the data replaces it if it has better
+// reception quality, or if it is a fresher report of the same source.
+func (t *track) update(d Data) bool {
+	diff := d.Time.Sub(t.last.Time)
+	switch {
+	case diff > fusionTimeTolerance:
+		return true
+	case diff < -fusionTimeTolerance:
+		return false
+	case better(d, t.last):
+		return true
+	default:
+		return d.Source == t.last.Source && diff > 0
+	}
+}
+
+// prune removes tracks that were not updated within the time window.
+func (f *Fusion) prune(now time.Time) {
+	if now.Sub(f.pruned) < f.window {
+		return
+	}
+	f.pruned = now
+	for key, t := range f.tracks {
+		if t.seenBy(now, f.window) == "" {
+			delete(f.tracks, key)
+		}
+	}
+}
+
+// seenBy returns comma separated sorted names of the sources that reported the aircraft within the
+// time window.
+func (t *track) seenBy(now time.Time, window time.Duration) string {
+	var names []string
+	for name, seen := range t.seen {
+		if now.Sub(seen) <= window {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return strings.Join(names, ",")
+}
+
+// better returns whether data a has better reception quality than data b.
+func better(a, b Data) bool {
+	return a.Reception != nil && b.Reception != nil && a.Reception.SNR > b.Reception.SNR
+}
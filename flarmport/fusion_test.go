@@ -0,0 +1,149 @@
+package flarmport
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFusionData(t *testing.T) {
+	t.Parallel()
+
+	f := Fuse(FusionConfig{WindowSec: 5})
+	var got []Data
+	h := Handler{Data: func(d Data) { got = append(got, d) }}
+
+	t0 := time.Now()
+	f.data("flarm", Data{Address: "X", Time: t0}, h)
+	f.data("ogn", Data{Address: "X", Time: t0}, h)                      // Duplicate.
+	f.data("ogn", Data{Address: "X", Time: t0.Add(2 * time.Second)}, h) // Fresher.
+	f.data("flarm", Data{Address: "X", Time: t0}, h)                    // Older.
+	f.data("ogn", Data{Address: "Y", Time: t0, Reception: &Reception{SNR: 10}}, h)
+	f.data("aprs", Data{Address: "Y", Time: t0, Reception: &Reception{SNR: 5}}, h)  // Worse.
+	f.data("port", Data{Address: "Y", Time: t0, Reception: &Reception{SNR: 20}}, h) // Better.
+	f.data("flarm", Data{Address: "X", Time: t0.Add(10 * time.Second)}, h)          // New track.
+
+	want := []Data{
+		{Address: "X", Time: t0, Source: "flarm", SeenBy: "flarm"},
+		{Address: "X", Time: t0.Add(2 * time.Second), Source: "ogn", SeenBy: "flarm,ogn"},
+		{Address: "Y", Time: t0, Reception: &Reception{SNR: 10}, Source: "ogn", SeenBy: "ogn"},
+		{Address: "Y", Time: t0, Reception: &Reception{SNR: 20}, Source: "port", SeenBy: "aprs,ogn,port"},
+		{Address: "X", Time: t0.Add(10 * time.Second), Source: "flarm", SeenBy: "flarm"},
+	}
+	assert.Equal(t, want, got)
+}
+
+func TestFusionDataTolerance(t *testing.T) {
+	t.Parallel()
+
+	f := Fuse(FusionConfig{WindowSec: 5})
+	var got []Data
+	h := Handler{Data: func(d Data) { got = append(got, d) }}
+
+	ms := time.Millisecond
+	t0 := time.Now()
+	f.data("ogn", Data{Address: "X", Time: t0.Add(500 * ms), Reception: &Reception{SNR: 10}}, h)
+	f.data("ogn", Data{Address: "X", Time: t0.Add(700 * ms), Reception: &Reception{SNR: 10}}, h)  // Fresher of same source.
+	f.data("port", Data{Address: "X", Time: t0.Add(1000 * ms), Reception: &Reception{SNR: 5}}, h) // Simultaneous and worse.
+	f.data("port", Data{Address: "X", Time: t0, Reception: &Reception{SNR: 20}}, h)               // Skewed and better.
+	f.data("ogn", Data{Address: "X", Time: t0.Add(800 * ms), Reception: &Reception{SNR: 10}}, h)  // Simultaneous and worse.
+	f.data("flarm", Data{Address: "X", Time: t0.Add(-2000 * ms)}, h)                              // Older.
+	f.data("ogn", Data{Address: "X", Time: t0.Add(1500 * ms), Reception: &Reception{SNR: 10}}, h) // Fresher.
+
+	want := []Data{
+		{Address: "X", Time: t0.Add(500 * ms), Reception: &Reception{SNR: 10}, Source: "ogn", SeenBy: "ogn"},
+		{Address: "X", Time: t0.Add(700 * ms), Reception: &Reception{SNR: 10}, Source: "ogn", SeenBy: "ogn"},
+		{Address: "X", Time: t0, Reception: &Reception{SNR: 20}, Source: "port", SeenBy: "ogn,port"},
+		{Address: "X", Time: t0.Add(1500 * ms), Reception: &Reception{SNR: 10}, Source: "ogn", SeenBy: "flarm,ogn,port"},
+	}
+	assert.Equal(t, want, got)
+}
+
+func TestFusionDataRangeOnly(t *testing.T) {
+	t.Parallel()
+
+	f := Fuse(FusionConfig{WindowSec: 5})
+	var got []Data
+	h := Handler{Data: func(d Data) { got = append(got, d) }}
+
+	// Two simultaneous Mode-C targets without an address.
+	t0 := time.Now()
+	f.data("flarm", Data{Kind: KindRangeOnly, Name: "Mode-C", Distance: 1000, Time: t0}, h)
+	f.data("flarm", Data{Kind: KindRangeOnly, Name: "Mode-C", Distance: 2000, Time: t0}, h)
+	// A Mode-S target and a position of the same address are tracked separately.
+	f.data("flarm", Data{Kind: KindRangeOnly, Name: "Mode-C", Address: "X", Distance: 3000, Time: t0}, h)
+	f.data("flarm", Data{Kind: KindRangeOnly, Name: "Mode-C", Address: "X", Distance: 3000, Time: t0}, h) // Duplicate.
+	f.data("ogn", Data{Kind: KindPosition, Address: "X", Time: t0}, h)
+
+	want := []Data{
+		{Kind: KindRangeOnly, Name: "Mode-C", Distance: 1000, Time: t0, Source: "flarm", SeenBy: "flarm"},
+		{Kind: KindRangeOnly, Name: "Mode-C", Distance: 2000, Time: t0, Source: "flarm", SeenBy: "flarm"},
+		{Kind: KindRangeOnly, Name: "Mode-C", Address: "X", Distance: 3000, Time: t0, Source: "flarm", SeenBy: "flarm"},
+		{Kind: KindPosition, Address: "X", Time: t0, Source: "ogn", SeenBy: "ogn"},
+	}
+	assert.Equal(t, want, got)
+}
+
+func TestFusionRange(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	now := time.Now()
+	f := Fuse(FusionConfig{},
+		Source{Name: "a", Open: func() (Reader, error) { return newFakeReader(Data{Address: "A", Time: now}), nil }},
+		Source{Name: "b", Open: func() (Reader, error) { return newFakeReader(Data{Address: "B", Time: now}), nil }},
+	)
+
+	got := make(chan Data, 2)
+	done := make(chan error)
+	go func() {
+		done <- f.RangeHandler(ctx, Handler{Data: func(d Data) { got <- d }})
+	}()
+
+	sources := map[string]bool{}
+	for i := 0; i < 2; i++ {
+		select {
+		case d := <-got:
+			sources[d.Source] = true
+		case <-time.After(5 * time.Second):
+			t.Fatal("Timeout waiting for data")
+		}
+	}
+	assert.Equal(t, map[string]bool{"a": true, "b": true}, sources)
+
+	cancel()
+	select {
+	case err := <-done:
+		require.Equal(t, context.Canceled, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Range did not return after cancel")
+	}
+}
+
+// fakeReader reports a single data value, and blocks until it is closed.
+type fakeReader struct {
+	data   Data
+	closed chan struct{}
+	once   sync.Once
+}
+
+func newFakeReader(d Data) *fakeReader {
+	return &fakeReader{data: d, closed: make(chan struct{})}
+}
+
+func (r *fakeReader) Range(ctx context.Context, f func(Data)) error {
+	f(r.data)
+	<-r.closed
+	return nil
+}
+
+func (r *fakeReader) Close() error {
+	r.once.Do(func() { close(r.closed) })
+	return nil
+}
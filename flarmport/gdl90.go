@@ -0,0 +1,584 @@
+package flarmport
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"strings"
+	"time"
+)
+
+// GDL90 message IDs.
+// Specification at: https://www.faa.gov/air_traffic/technology/adsb/archival/media/gdl90_public_icd_reva.pdf.
+const (
+	gdl90Heartbeat   = 0x00
+	gdl90Ownship     = 0x0A
+	gdl90OwnshipAlt  = 0x0B
+	gdl90Traffic     = 0x14
+	gdl90ReportLen   = 28
+	gdl90FlagByte    = 0x7E
+	gdl90ControlByte = 0x7D
+	gdl90EscapeXor   = 0x20
+
+	defaultGDL90Callsign = "FLARM"
+)
+
+// GDL90 address types.
+const (
+	gdl90ICAO         = 0
+	gdl90SelfAssigned = 1
+)
+
+// gdl90CRCTable is the CRC-16-CCITT table, as defined in the specification.
+var gdl90CRCTable = func() [256]uint16 {
+	var t [256]uint16
+	for i := range t {
+		crc := uint16(i) << 8
+		for bit := 0; bit < 8; bit++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+		t[i] = crc
+	}
+	return t
+}()
+
+func gdl90CRC(msg []byte) uint16 {
+	var crc uint16
+	for _, b := range msg {
+		crc = gdl90CRCTable[crc>>8] ^ crc<<8 ^ uint16(b)
+	}
+	return crc
+}
+
+// gdl90Frame appends the CRC to the message, escapes it and wraps it with flag bytes.
+func gdl90Frame(msg []byte) []byte {
+	crc := gdl90CRC(msg)
+	msg = append(msg, byte(crc), byte(crc>>8))
+	frame := []byte{gdl90FlagByte}
+	for _, b := range msg {
+		if b == gdl90FlagByte || b == gdl90ControlByte {
+			frame = append(frame, gdl90ControlByte, b^gdl90EscapeXor)
+		} else {
+			frame = append(frame, b)
+		}
+	}
+	return append(frame, gdl90FlagByte)
+}
+
+// gdl90Unframe returns the message of a frame without the flag bytes, after unescaping it and
+// validating its CRC.
+func gdl90Unframe(frame []byte) ([]byte, error) {
+	var msg []byte
+	for i := 0; i < len(frame); i++ {
+		b := frame[i]
+		if b == gdl90ControlByte {
+			i++
+			if i == len(frame) {
+				return nil, errors.New("gdl90 frame ends with control byte")
+			}
+			b = frame[i] ^ gdl90EscapeXor
+		}
+		msg = append(msg, b)
+	}
+	if len(msg) < 3 {
+		return nil, fmt.Errorf("gdl90 frame too short: %d bytes", len(msg))
+	}
+	crc := binary.LittleEndian.Uint16(msg[len(msg)-2:])
+	msg = msg[:len(msg)-2]
+	if got := gdl90CRC(msg); got != crc {
+		return nil, fmt.Errorf("gdl90 bad crc: %04x != %04x", got, crc)
+	}
+	return msg, nil
+}
+
+// gdl90Report is a traffic or ownship report.
+type gdl90Report struct {
+	alert       bool
+	addressType byte
+	address     uint32
+	lat, long   float64
+	// Pressure altitude in feet.
+	alt      float64
+	airborne bool
+	// Horizontal velocity in knots.
+	speed float64
+	// Vertical velocity in feet per minute.
+	climb    float64
+	track    float64
+	emitter  byte
+	callsign string
+}
+
+// encode encodes the report as a message with the given ID.
+func (r gdl90Report) encode(id byte) []byte {
+	msg := make([]byte, gdl90ReportLen)
+	msg[0] = id
+	if r.alert {
+		msg[1] = 1 << 4
+	}
+	msg[1] |= r.addressType & 0xF
+	put24(msg[2:], r.address)
+	put24(msg[5:], uint32(int32(math.Round(r.lat/gdl90LatLongResolution))))
+	put24(msg[8:], uint32(int32(math.Round(r.long/gdl90LatLongResolution))))
+
+	alt := int(math.Round((r.alt + 1000) / 25))
+	if alt < 0 || alt >= 0xFFF {
+		alt = 0xFFF
+	}
+	// Miscellaneous indicators: true track angle, updated report.
+	misc := 0x1
+	if r.airborne {
+		misc |= 0x8
+	}
+	msg[11] = byte(alt >> 4)
+	msg[12] = byte(alt<<4) | byte(misc)
+	// Integrity and accuracy: NIC 8 (< 0.1 NM), NACp 8 (< 93 m).
+	msg[13] = 0x88
+
+	speed := int(math.Round(r.speed))
+	if speed > 0xFFE {
+		speed = 0xFFE
+	}
+	climb := int(math.Round(r.climb / 64))
+	if climb > 0x1FD {
+		climb = 0x1FD
+	} else if climb < -0x1FD {
+		climb = -0x1FD
+	}
+	msg[14] = byte(speed >> 4)
+	msg[15] = byte(speed<<4) | byte(climb>>8)&0xF
+	msg[16] = byte(climb)
+	msg[17] = byte(int(math.Round(math.Mod(r.track, 360) / 360 * 256)))
+	msg[18] = r.emitter
+	copy(msg[19:27], fmt.Sprintf("%-8.8s", r.callsign))
+	return msg
+}
+
+// decodeGDL90Report decodes a traffic or ownship report message.
+func decodeGDL90Report(msg []byte) (gdl90Report, error) {
+	if len(msg) < gdl90ReportLen {
+		return gdl90Report{}, fmt.Errorf("gdl90 report too short: %d bytes", len(msg))
+	}
+	r := gdl90Report{
+		alert:       msg[1]>>4 != 0,
+		addressType: msg[1] & 0xF,
+		address:     get24(msg[2:]),
+		lat:         float64(signed24(get24(msg[5:]))) * gdl90LatLongResolution,
+		long:        float64(signed24(get24(msg[8:]))) * gdl90LatLongResolution,
+		alt:         math.NaN(),
+		airborne:    msg[12]&0x8 != 0,
+		speed:       math.NaN(),
+		climb:       math.NaN(),
+		track:       float64(msg[17]) * 360 / 256,
+		emitter:     msg[18],
+		callsign:    strings.TrimSpace(string(msg[19:27])),
+	}
+	if alt := int(msg[11])<<4 | int(msg[12])>>4; alt != 0xFFF {
+		r.alt = float64(alt*25 - 1000)
+	}
+	if speed := int(msg[14])<<4 | int(msg[15])>>4; speed != 0xFFF {
+		r.speed = float64(speed)
+	}
+	if climb := uint32(msg[15]&0xF)<<8 | uint32(msg[16]); climb != 0x800 {
+		// Sign extend the 12 bits value.
+		r.climb = float64(int32(climb<<20)>>20) * 64
+	}
+	return r, nil
+}
+
+// gdl90LatLongResolution is the resolution of latitude and longitude values, in degrees.
+const gdl90LatLongResolution = 180.0 / (1 << 23)
+
+func get24(b []byte) uint32 {
+	return uint32(b[0])<<16 | uint32(b[1])<<8 | uint32(b[2])
+}
+
+// signed24 sign extends a 24 bits value.
+func signed24(v uint32) int32 {
+	return int32(v<<8) >> 8
+}
+
+func put24(b []byte, v uint32) {
+	b[0] = byte(v >> 16)
+	b[1] = byte(v >> 8)
+	b[2] = byte(v)
+}
+
+// gdl90HeartbeatMessage returns a heartbeat message for the given time.
+func gdl90HeartbeatMessage(t time.Time, gpsValid bool) []byte {
+	t = t.UTC()
+	ts := t.Hour()*3600 + t.Minute()*60 + t.Second()
+	msg := make([]byte, 7)
+	msg[0] = gdl90Heartbeat
+	// UAT initialized.
+	msg[1] = 0x01
+	if gpsValid {
+		msg[1] |= 0x80
+	}
+	// UTC OK and timestamp bit 16.
+	msg[2] = 0x01 | byte(ts>>16)<<7
+	binary.LittleEndian.PutUint16(msg[3:], uint16(ts))
+	return msg
+}
+
+// gdl90OwnshipAltMessage returns an ownship geometric altitude message.
+func gdl90OwnshipAltMessage(altFeet float64) []byte {
+	msg := make([]byte, 5)
+	msg[0] = gdl90OwnshipAlt
+	binary.BigEndian.PutUint16(msg[1:], uint16(int16(math.Round(altFeet/5))))
+	// Vertical figure of merit: not available.
+	binary.BigEndian.PutUint16(msg[3:], 0x7FFF)
+	return msg
+}
+
+// gdl90Emitter returns the GDL90 emitter category of an aircraft type returned by aircraftType.
+func gdl90Emitter(tp string) byte {
+	switch aircraftTypeCode(tp) {
+	case "1":
+		return 9 // Glider / sailplane.
+	case "2", "5", "8":
+		return 1 // Light.
+	case "3":
+		return 7 // Rotorcraft.
+	case "4":
+		return 11 // Parachutist / skydiver.
+	case "6", "7":
+		return 12 // Ultralight / hang-glider / paraglider.
+	case "9":
+		return 3 // Large.
+	case "B", "C":
+		return 10 // Lighter than air.
+	case "D":
+		return 14 // Unmanned aerial vehicle.
+	case "F":
+		return 19 // Point obstacle.
+	}
+	return 0 // No information.
+}
+
+// gdl90AircraftType returns the aircraft type, as returned by aircraftType, of a GDL90 emitter
+// category.
+func gdl90AircraftType(emitter byte) string {
+	switch emitter {
+	case 9:
+		return aircraftType("1")
+	case 1:
+		return aircraftType("8")
+	case 2, 3, 4, 5, 6:
+		return aircraftType("9")
+	case 7:
+		return aircraftType("3")
+	case 11:
+		return aircraftType("4")
+	case 12:
+		return aircraftType("6")
+	case 10:
+		return aircraftType("B")
+	case 14:
+		return aircraftType("D")
+	case 19:
+		return aircraftType("F")
+	}
+	return aircraftType("0")
+}
+
+// GDL90Config is configuration for broadcasting GDL90 to EFB applications.
+type GDL90Config struct {
+	// Addrs are the UDP destination addresses, for example "192.168.1.255:4000" for broadcast, or
+	// the address of a specific tablet. If empty, GDL90 broadcasting is disabled.
+	Addrs []string
+	// Callsign of the ownship report. Default is "FLARM".
+	Callsign string
+}
+
+// GDL90Broadcaster broadcasts received data as GDL90 traffic reports over UDP, with a heartbeat
+// and an ownship report of the station location every second.
+type GDL90Broadcaster struct {
+	*udpSender
+	cfg     GDL90Config
+	station StationInfo
+}
+
+// NewGDL90Broadcaster starts broadcasting GDL90. It returns nil if broadcasting is disabled in the
+// configuration.
+func NewGDL90Broadcaster(cfg GDL90Config, station StationInfo) (*GDL90Broadcaster, error) {
+	if len(cfg.Addrs) == 0 {
+		return nil, nil
+	}
+	if cfg.Callsign == "" {
+		cfg.Callsign = defaultGDL90Callsign
+	}
+	sender, err := newUDPSender("gdl90", cfg.Addrs)
+	if err != nil {
+		return nil, err
+	}
+	b := &GDL90Broadcaster{
+		udpSender: sender,
+		cfg:       cfg,
+		station:   station,
+	}
+	go b.heartbeat()
+	return b, nil
+}
+
+// Data broadcasts a traffic report of the given data. Range-only targets are not broadcasted,
+// since they have no position.
+func (b *GDL90Broadcaster) Data(d Data) {
+	if b == nil || d.Kind == KindRangeOnly {
+		return
+	}
+	b.sendTo(gdl90Frame(gdl90TrafficReport(d).encode(gdl90Traffic)))
+}
+
+// Close stops broadcasting.
+func (b *GDL90Broadcaster) Close() error {
+	if b == nil {
+		return nil
+	}
+	return b.close()
+}
+
+func (b *GDL90Broadcaster) heartbeat() {
+	t := time.NewTicker(time.Second)
+	defer t.Stop()
+	for {
+		select {
+		case <-b.done:
+			return
+		case now := <-t.C:
+			b.sendTo(gdl90Frame(gdl90HeartbeatMessage(now, true)))
+			b.sendTo(gdl90Frame(b.ownship().encode(gdl90Ownship)))
+			b.sendTo(gdl90Frame(gdl90OwnshipAltMessage(b.station.Alt / feetToMeters)))
+		}
+	}
+}
+
+// ownship returns the ownship report of the station.
+func (b *GDL90Broadcaster) ownship() gdl90Report {
+	return gdl90Report{
+		lat:      b.station.Lat,
+		long:     b.station.Long,
+		alt:      b.station.Alt / feetToMeters,
+		callsign: b.cfg.Callsign,
+	}
+}
+
+// gdl90TrafficReport returns the traffic report of the given data.
+func gdl90TrafficReport(d Data) gdl90Report {
+	r := gdl90Report{
+		alert:       d.AlarmLevel > 0,
+		addressType: gdl90SelfAssigned,
+		lat:         d.Lat,
+		long:        d.Long,
+		alt:         d.Alt / feetToMeters,
+		airborne:    d.GroundSpeed > 0,
+		speed:       float64(d.GroundSpeed) / knotsToMS,
+		climb:       d.Climb / fpmToMS,
+		track:       float64(d.Dir),
+		emitter:     gdl90Emitter(d.Type),
+		callsign:    gdl90Callsign(d.Name),
+	}
+	if d.PressureAlt != nil {
+		r.alt = *d.PressureAlt / feetToMeters
+	}
+	if d.AddressType == "official" {
+		r.addressType = gdl90ICAO
+	}
+	var address uint32
+	fmt.Sscanf(d.Address, "%x", &address)
+	r.address = address
+	return r
+}
+
+// gdl90Callsign returns a callsign that contains only the characters allowed by the specification.
+func gdl90Callsign(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		}
+		return -1
+	}, name)
+}
+
+// GDL90 is a reader of GDL90 traffic and ownship reports over UDP, as sent by receivers such as
+// Stratux or SoftRF.
+type GDL90 struct {
+	conn    net.PacketConn
+	station StationInfo
+	buf     []byte
+	// pending holds the decoded data of the last datagram that was not returned yet.
+	pending []*Data
+	// utc is the last time that was received in a heartbeat, and utcUpdated is when it was
+	// received.
+	utc        time.Time
+	utcUpdated time.Time
+}
+
+// OpenGDL90 listens for GDL90 datagrams on the given UDP address, e.g. ":4000".
+func OpenGDL90(addr string, station StationInfo) (*GDL90, error) {
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed listening on %s: %v", addr, err)
+	}
+	return newGDL90(conn, station), nil
+}
+
+func newGDL90(conn net.PacketConn, station StationInfo) *GDL90 {
+	if station.TimeZone == nil {
+		station.TimeZone = defaultTimezone
+	}
+	if station.QNH == 0 {
+		station.QNH = StandardQNH
+	}
+	return &GDL90{
+		conn:    conn,
+		station: station,
+		buf:     make([]byte, 65536),
+	}
+}
+
+// Range iterates over the positions of other aircraft. See RangeHandler for reading all values.
+func (g *GDL90) Range(ctx context.Context, f func(Data)) error {
+	return g.RangeHandler(ctx, Handler{Data: f})
+}
+
+// RangeHandler iterates and parses data from the UDP connection. It exists when the connection is
+// closed.
+func (g *GDL90) RangeHandler(ctx context.Context, h Handler) error {
+	for ctx.Err() == nil {
+		value, ok := g.next()
+		if !ok {
+			return nil
+		}
+		if ctx.Err() == nil {
+			h.handle(value)
+		}
+	}
+	return ctx.Err()
+}
+
+// Close closes the UDP connection.
+func (g *GDL90) Close() error {
+	return g.conn.Close()
+}
+
+// next used by Range and exist for testing purposes.
+func (g *GDL90) next() (*Data, bool) {
+	if len(g.pending) > 0 {
+		d := g.pending[0]
+		g.pending = g.pending[1:]
+		return d, true
+	}
+	n, _, err := g.conn.ReadFrom(g.buf)
+	if err != nil {
+		// Stop reading.
+		return nil, false
+	}
+	// A datagram may contain multiple frames, separated by flag bytes.
+	for _, frame := range bytes.Split(g.buf[:n], []byte{gdl90FlagByte}) {
+		if len(frame) == 0 {
+			continue
+		}
+		msg, err := gdl90Unframe(frame)
+		if err != nil {
+			log.Printf("Ignoring gdl90 frame: %v", err)
+			continue
+		}
+		if d := g.process(msg); d != nil {
+			g.pending = append(g.pending, d)
+		}
+	}
+	return nil, true
+}
+
+// process processes a GDL90 message, and returns data for traffic and ownship reports.
+func (g *GDL90) process(msg []byte) *Data {
+	switch msg[0] {
+	case gdl90Heartbeat:
+		if len(msg) < 7 || msg[2]&0x01 == 0 {
+			// UTC time is not valid.
+			return nil
+		}
+		ts := time.Duration(msg[2]>>7)<<16 | time.Duration(binary.LittleEndian.Uint16(msg[3:]))
+		g.utc = timeOfDay(time.Time{}.Add(ts*time.Second).Format("150405"), time.Now())
+		g.utcUpdated = time.Now()
+		return nil
+	case gdl90Traffic, gdl90Ownship:
+		r, err := decodeGDL90Report(msg)
+		if err != nil {
+			log.Printf("Ignoring gdl90 report: %v", err)
+			return nil
+		}
+		return g.data(r)
+	}
+	return nil
+}
+
+// data converts a report to data.
+func (g *GDL90) data(r gdl90Report) *Data {
+	// Reports without a position.
+	if r.lat == 0 && r.long == 0 {
+		return nil
+	}
+	var gps time.Time
+	if !g.utcUpdated.IsZero() && time.Since(g.utcUpdated) < fixTimeout {
+		gps = g.utc.Add(time.Since(g.utcUpdated))
+	}
+	t, ok := g.station.timestamp(gps)
+	if !ok {
+		return nil
+	}
+	d := &Data{
+		Kind:   KindPosition,
+		Source: SourceGDL90,
+		Lat:    r.lat,
+		Long:   r.long,
+		Dir:    int(math.Round(r.track)),
+		Type:   gdl90AircraftType(r.emitter),
+		Time:   t,
+	}
+	if r.alert {
+		d.AlarmLevel = 1
+	}
+	if !math.IsNaN(r.speed) {
+		d.GroundSpeed = int64(math.Round(r.speed * knotsToMS))
+	}
+	if !math.IsNaN(r.climb) {
+		d.Climb = math.Round(r.climb*fpmToMS*10) / 10
+	}
+	// GDL90 reports pressure altitude.
+	if !math.IsNaN(r.alt) {
+		g.station.setPressureAlt(d, r.alt*feetToMeters)
+		d.Alt = *d.QNHAlt
+	}
+
+	address := fmt.Sprintf("%06X", r.address)
+	g.station.identify(d, address, gdl90AddressType(r.addressType))
+	if _, ok := g.station.IDMap[address]; !ok && r.callsign != "" {
+		d.Name = r.callsign
+	}
+	return d
+}
+
+// gdl90AddressType returns the address type of a GDL90 address type.
+func gdl90AddressType(t byte) string {
+	switch t {
+	case gdl90ICAO, 2: // ADS-B or TIS-B with ICAO address.
+		return "official"
+	case gdl90SelfAssigned:
+		return "flarm id"
+	}
+	return "unknown"
+}
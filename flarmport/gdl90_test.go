@@ -0,0 +1,227 @@
+package flarmport
+
+import (
+	"math"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGDL90Frame(t *testing.T) {
+	t.Parallel()
+
+	// Heartbeat example from the specification.
+	msg := []byte{0x00, 0x81, 0x41, 0xDB, 0xD0, 0x08, 0x02}
+	frame := []byte{0x7E, 0x00, 0x81, 0x41, 0xDB, 0xD0, 0x08, 0x02, 0xB3, 0x8B, 0x7E}
+	assert.Equal(t, frame, gdl90Frame(msg))
+
+	got, err := gdl90Unframe(frame[1 : len(frame)-1])
+	require.NoError(t, err)
+	assert.Equal(t, msg, got)
+
+	// Escaping.
+	msg = []byte{0x14, 0x7E, 0x7D, 0x01}
+	frame = gdl90Frame(msg)
+	assert.Equal(t, []byte{0x7E, 0x14, 0x7D, 0x5E, 0x7D, 0x5D, 0x01}, frame[:7])
+	got, err = gdl90Unframe(frame[1 : len(frame)-1])
+	require.NoError(t, err)
+	assert.Equal(t, msg, got)
+
+	// Bad CRC.
+	frame[3] ^= 0xFF
+	_, err = gdl90Unframe(frame[1 : len(frame)-1])
+	assert.Error(t, err)
+}
+
+func TestGDL90Report(t *testing.T) {
+	t.Parallel()
+
+	// Traffic report example from the specification.
+	r := gdl90Report{
+		addressType: gdl90ICAO,
+		address:     0xAB4549,
+		lat:         0x1FEF15 * gdl90LatLongResolution,  // 44.90708.
+		long:        -0x577688 * gdl90LatLongResolution, // -122.99488.
+		alt:         5000,
+		airborne:    true,
+		speed:       123,
+		climb:       64,
+		track:       45,
+		emitter:     1,
+		callsign:    "N825V",
+	}
+	want := []byte{
+		0x14, 0x00, 0xAB, 0x45, 0x49, 0x1F, 0xEF, 0x15, 0xA8, 0x89, 0x78, 0x0F, 0x09,
+		0x88, // Integrity and accuracy are different from the example.
+		0x07, 0xB0, 0x01, 0x20, 0x01, 0x4E, 0x38, 0x32, 0x35, 0x56, 0x20, 0x20, 0x20, 0x00,
+	}
+	assert.Equal(t, want, r.encode(gdl90Traffic))
+}
+
+func TestGDL90TrafficReport(t *testing.T) {
+	t.Parallel()
+
+	pressureAlt := 1000.0
+	r := gdl90TrafficReport(Data{
+		Name:        "4x-gdl",
+		Address:     "DD8E8B",
+		AddressType: "flarm id",
+		Alt:         1200,
+		PressureAlt: &pressureAlt,
+		GroundSpeed: 30,
+		Climb:       -2,
+		Dir:         270,
+		Type:        "glider",
+		AlarmLevel:  2,
+	})
+	assert.True(t, r.alert)
+	assert.Equal(t, byte(gdl90SelfAssigned), r.addressType)
+	assert.Equal(t, uint32(0xDD8E8B), r.address)
+	assert.InDelta(t, 3280.84, r.alt, 0.01)
+	assert.InDelta(t, 58.3, r.speed, 0.1)
+	assert.InDelta(t, -393.7, r.climb, 0.1)
+	assert.Equal(t, byte(9), r.emitter)
+	assert.Equal(t, "4XGDL", r.callsign)
+}
+
+func TestGDL90Broadcaster(t *testing.T) {
+	t.Parallel()
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer conn.Close()
+
+	b, err := NewGDL90Broadcaster(GDL90Config{Addrs: []string{conn.LocalAddr().String()}}, StationInfo{Lat: 32.5, Long: 35.2})
+	require.NoError(t, err)
+	defer b.Close()
+
+	b.Data(Data{Kind: KindRangeOnly, Distance: 100}) // Not broadcasted.
+	b.Data(Data{Kind: KindPosition, Address: "DD8E8B", Lat: 32.6, Long: 35.3})
+
+	got := map[byte]int{}
+	buf := make([]byte, 1024)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for got[gdl90Heartbeat] == 0 || got[gdl90Ownship] == 0 || got[gdl90OwnshipAlt] == 0 {
+		n, _, err := conn.ReadFrom(buf)
+		require.NoError(t, err)
+		require.True(t, n > 2)
+		assert.Equal(t, byte(gdl90FlagByte), buf[0])
+		assert.Equal(t, byte(gdl90FlagByte), buf[n-1])
+		msg, err := gdl90Unframe(buf[1 : n-1])
+		require.NoError(t, err)
+		got[msg[0]]++
+	}
+	assert.Equal(t, 1, got[gdl90Traffic])
+}
+
+func TestGDL90BroadcasterDisabled(t *testing.T) {
+	t.Parallel()
+
+	b, err := NewGDL90Broadcaster(GDL90Config{}, StationInfo{})
+	require.NoError(t, err)
+	assert.Nil(t, b)
+	b.Data(Data{})
+	assert.NoError(t, b.Close())
+}
+
+func TestDecodeGDL90Report(t *testing.T) {
+	t.Parallel()
+
+	// Traffic report example from the specification.
+	msg := []byte{
+		0x14, 0x00, 0xAB, 0x45, 0x49, 0x1F, 0xEF, 0x15, 0xA8, 0x89, 0x78, 0x0F, 0x09, 0xA9, 0x07, 0xB0,
+		0x01, 0x20, 0x01, 0x4E, 0x38, 0x32, 0x35, 0x56, 0x20, 0x20, 0x20, 0x00,
+	}
+	r, err := decodeGDL90Report(msg)
+	require.NoError(t, err)
+	assert.Equal(t, byte(gdl90ICAO), r.addressType)
+	assert.Equal(t, uint32(0xAB4549), r.address)
+	assert.InDelta(t, 44.90708, r.lat, 1e-4)
+	assert.InDelta(t, -122.99488, r.long, 1e-4)
+	assert.Equal(t, 5000.0, r.alt)
+	assert.True(t, r.airborne)
+	assert.Equal(t, 123.0, r.speed)
+	assert.Equal(t, 64.0, r.climb)
+	assert.Equal(t, 45.0, r.track)
+	assert.Equal(t, byte(1), r.emitter)
+	assert.Equal(t, "N825V", r.callsign)
+
+	// Negative vertical velocity and unknown altitude.
+	r.climb = -640
+	r.alt = 1e6
+	r, err = decodeGDL90Report(r.encode(gdl90Traffic))
+	require.NoError(t, err)
+	assert.Equal(t, -640.0, r.climb)
+	assert.True(t, math.IsNaN(r.alt))
+
+	_, err = decodeGDL90Report(msg[:10])
+	assert.Error(t, err)
+}
+
+func TestGDL90Reader(t *testing.T) {
+	t.Parallel()
+
+	g, err := OpenGDL90("127.0.0.1:0", StationInfo{IDMap: map[string]Aircraft{"DD8E8B": {Name: "APL"}}})
+	require.NoError(t, err)
+	defer g.Close()
+
+	conn, err := net.Dial("udp", g.conn.LocalAddr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	now := time.Now()
+	traffic := gdl90Report{
+		alert:       true,
+		addressType: gdl90SelfAssigned,
+		address:     0xDD8E8B,
+		lat:         32.5,
+		long:        35.2,
+		alt:         3000,
+		airborne:    true,
+		speed:       50,
+		climb:       -256,
+		track:       90,
+		emitter:     9,
+	}
+	ownship := gdl90Report{addressType: gdl90ICAO, address: 0x738065, lat: 32.6, long: 35.3, alt: 1000, callsign: "4XGDL"}
+	var datagram []byte
+	datagram = append(datagram, gdl90Frame(gdl90HeartbeatMessage(now, true))...)
+	datagram = append(datagram, gdl90Frame(traffic.encode(gdl90Traffic))...)
+	datagram = append(datagram, gdl90Frame(ownship.encode(gdl90Ownship))...)
+	// Corrupted frame is ignored.
+	datagram = append(datagram, gdl90FlagByte, 0x14, 0x01, 0x02, gdl90FlagByte)
+	_, err = conn.Write(datagram)
+	require.NoError(t, err)
+
+	got, ok := g.next()
+	require.True(t, ok)
+	assert.Nil(t, got)
+
+	got, ok = g.next()
+	require.True(t, ok)
+	require.NotNil(t, got)
+	assert.WithinDuration(t, now, got.Time, 2*time.Second)
+	assert.Equal(t, SourceGDL90, got.Source)
+	assert.Equal(t, "APL", got.Name)
+	assert.Equal(t, "DD8E8B", got.Address)
+	assert.Equal(t, "flarm id", got.AddressType)
+	assert.Equal(t, 1, got.AlarmLevel)
+	assert.InDelta(t, 32.5, got.Lat, 1e-5)
+	assert.InDelta(t, 35.2, got.Long, 1e-5)
+	assert.InDelta(t, 914.4, *got.PressureAlt, 1e-6)
+	assert.InDelta(t, 914.4, got.Alt, 1e-6)
+	assert.Equal(t, int64(26), got.GroundSpeed)
+	assert.Equal(t, -1.3, got.Climb)
+	assert.Equal(t, 90, got.Dir)
+	assert.Equal(t, "glider", got.Type)
+
+	got, ok = g.next()
+	require.True(t, ok)
+	require.NotNil(t, got)
+	assert.Equal(t, "4XGDL", got.Name)
+	assert.Equal(t, "738065", got.Address)
+	assert.Equal(t, "official", got.AddressType)
+}
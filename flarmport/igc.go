@@ -0,0 +1,132 @@
+package flarmport
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// igcEOF marks the end of the IGC data.
+const igcEOF = 0x1A
+
+// Flight is a flight that is recorded in the device.
+type Flight struct {
+	// Index of the record in the device. The most recent flight has index 0.
+	Index int
+	// Info is the record information as reported by the device. Its fields are separated by "|",
+	// and the first field is the IGC file name.
+	Info string
+}
+
+// FileName returns the IGC file name of the flight.
+func (f Flight) FileName() string {
+	name := strings.SplitN(f.Info, "|", 2)[0]
+	// Don't allow the device to write outside the target directory.
+	name = filepath.Base(strings.TrimSpace(name))
+	if !strings.EqualFold(filepath.Ext(name), ".igc") {
+		return fmt.Sprintf("flight-%d.igc", f.Index)
+	}
+	return name
+}
+
+// Flights lists the flights that are recorded in the device.
+func (s *binarySession) Flights(ctx context.Context) ([]Flight, error) {
+	var flights []Flight
+	for i := 0; i < 256; i++ {
+		err := s.selectRecord(ctx, i)
+		if errors.Is(err, errBinaryNACK) {
+			// No more records.
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		info, err := s.request(ctx, binaryRecordInfo, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed reading record %d info: %v", i, err)
+		}
+		flights = append(flights, Flight{Index: i, Info: strings.TrimRight(string(info), "\x00")})
+	}
+	return flights, nil
+}
+
+// Download downloads the IGC file of the flight with the given record index.
+func (s *binarySession) Download(ctx context.Context, index int) ([]byte, error) {
+	err := s.selectRecord(ctx, index)
+	if err != nil {
+		return nil, err
+	}
+	var data []byte
+	for {
+		chunk, err := s.request(ctx, binaryIGCData, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed reading record %d data: %v", index, err)
+		}
+		if len(chunk) < 1 {
+			return nil, fmt.Errorf("invalid record %d data", index)
+		}
+		// The first byte is the progress in percents.
+		chunk = chunk[1:]
+		if i := bytes.IndexByte(chunk, igcEOF); i >= 0 {
+			return append(data, chunk[:i]...), nil
+		}
+		data = append(data, chunk...)
+	}
+}
+
+func (s *binarySession) selectRecord(ctx context.Context, index int) error {
+	_, err := s.request(ctx, binarySelectRecord, []byte{byte(index)})
+	if err != nil {
+		return fmt.Errorf("failed selecting record %d: %w", index, err)
+	}
+	return nil
+}
+
+// DownloadIGC downloads the flights that are recorded in the device into IGC files in the given
+// directory. Flights that their file already exists are skipped. It returns the paths of the
+// downloaded files. It requires that the port is being ranged, and no data is read while
+// downloading.
+func (p *Port) DownloadIGC(ctx context.Context, dir string) ([]string, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, fmt.Errorf("failed creating igc dir: %v", err)
+	}
+
+	s, err := p.binarySession(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := s.Close(); err != nil {
+			log.Printf("Failed exiting binary mode: %v", err)
+		}
+	}()
+
+	flights, err := s.Flights(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var paths []string
+	for _, f := range flights {
+		path := filepath.Join(dir, f.FileName())
+		if _, err := os.Stat(path); err == nil {
+			continue
+		}
+		data, err := s.Download(ctx, f.Index)
+		if err != nil {
+			return paths, err
+		}
+		err = os.WriteFile(path, data, 0644)
+		if err != nil {
+			return paths, fmt.Errorf("failed writing igc file: %v", err)
+		}
+		log.Printf("Downloaded flight %s", path)
+		paths = append(paths, path)
+	}
+	return paths, nil
+}
@@ -0,0 +1,138 @@
+package flarmport
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFlightFileName(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, "3BTA3K71.IGC", Flight{Index: 0, Info: "3BTA3K71.IGC|2023-11-29|10:11:38|02:14:15"}.FileName())
+	assert.Equal(t, "a.igc", Flight{Index: 0, Info: "../../a.igc"}.FileName())
+	assert.Equal(t, "flight-3.igc", Flight{Index: 3, Info: ""}.FileName())
+}
+
+func TestDownloadIGC(t *testing.T) {
+	t.Parallel()
+
+	flights := []struct{ info, data string }{
+		{"3BTA3K71.IGC|2023-11-29|10:11:38|02:14:15", "AFLA3K7\r\nHFDTE291123\r\nB1011383230000N03512000EA0010000100\r\n"},
+		{"3BSA3K71.IGC|2023-11-28|09:00:00|01:00:00", "AFLA3K7\r\nHFDTE281123\r\n"},
+	}
+
+	conn, device := net.Pipe()
+	defer device.Close()
+	p := newPort(conn, StationInfo{})
+	defer p.Close()
+	statuses := make(chan *Status, 1)
+	go p.RangeHandler(context.Background(), Handler{Status: func(s Status) {
+		select {
+		case statuses <- &s:
+		default:
+		}
+	}})
+
+	// Requests that are made during the download.
+	rejected := make(chan error, 2)
+
+	// Simulate a device in binary mode.
+	go func() {
+		r := bufio.NewReader(device)
+		selected := 0
+		var pending string
+		for {
+			m, err := readBinaryMessage(r)
+			if err != nil {
+				return
+			}
+			ack := make([]byte, 2)
+			binary.LittleEndian.PutUint16(ack, m.seq)
+			typ := byte(binaryACK)
+			switch m.typ {
+			case binarySelectRecord:
+				selected = int(m.payload[0])
+				if selected >= len(flights) {
+					typ = binaryNACK
+				} else {
+					pending = flights[selected].data + "\x1A"
+				}
+			case binaryRecordInfo:
+				ack = append(ack, []byte(flights[selected].info)...)
+				if selected == 0 {
+					_, err := p.Config(context.Background(), "ID")
+					rejected <- err
+					rejected <- p.QueryDeviceStatus()
+				}
+			case binaryIGCData:
+				// Send the data in small chunks.
+				n := 16
+				if n > len(pending) {
+					n = len(pending)
+				}
+				ack = append(append(ack, 50), pending[:n]...)
+				pending = pending[n:]
+			}
+			device.Write(binaryMessage{seq: m.seq, typ: typ, payload: ack}.encode())
+			if m.typ == binaryExit {
+				// Back to NMEA mode.
+				for {
+					_, err := device.Write([]byte("$PFLAU,3,1,2,1,0,,0,,,*4F\r\n"))
+					if err != nil {
+						return
+					}
+					time.Sleep(10 * time.Millisecond)
+				}
+			}
+		}
+	}()
+
+	dir := t.TempDir()
+	// An existing flight is not downloaded.
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "3BSA3K71.IGC"), []byte("existing"), 0644))
+
+	paths, err := p.DownloadIGC(context.Background(), dir)
+	require.NoError(t, err)
+	assert.Equal(t, []string{filepath.Join(dir, "3BTA3K71.IGC")}, paths)
+
+	got, err := os.ReadFile(filepath.Join(dir, "3BTA3K71.IGC"))
+	require.NoError(t, err)
+	assert.Equal(t, flights[0].data, string(got))
+	got, err = os.ReadFile(filepath.Join(dir, "3BSA3K71.IGC"))
+	require.NoError(t, err)
+	assert.Equal(t, "existing", string(got))
+
+	// Configuration requests fail during the download.
+	assert.Equal(t, errBinarySession, <-rejected)
+	assert.Equal(t, errBinarySession, <-rejected)
+
+	// NMEA is read after returning from binary mode.
+	select {
+	case s := <-statuses:
+		assert.Equal(t, 3, s.Rx)
+	case <-time.After(5 * time.Second):
+		t.Fatal("no status after download")
+	}
+}
+
+func TestDownloadIGCReadOnly(t *testing.T) {
+	t.Parallel()
+
+	p := newPort(readOnlyConn{strings.NewReader("")}, StationInfo{})
+	_, err := p.DownloadIGC(context.Background(), t.TempDir())
+	assert.Error(t, err)
+}
+
+type readOnlyConn struct{ *strings.Reader }
+
+func (readOnlyConn) Close() error { return nil }
@@ -0,0 +1,132 @@
+package flarmport
+
+import (
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/adrianmo/go-nmea"
+)
+
+// LineClass is the classification of a line that is read from a source.
+type LineClass string
+
+const (
+	// LineValid is a line that was parsed and used.
+	LineValid LineClass = "valid"
+	// LineBadChecksum is an NMEA sentence with a wrong checksum.
+	LineBadChecksum LineClass = "bad checksum"
+	// LineUnknown is an NMEA sentence of a type that can't be parsed.
+	LineUnknown LineClass = "unknown sentence"
+	// LineMalformed is a line that could not be parsed, such as a partial sentence or a sentence
+	// with invalid fields.
+	LineMalformed LineClass = "malformed"
+	// LineUnsupported is a well formed line that is not used by the reader.
+	LineUnsupported LineClass = "unsupported"
+)
+
+// LineCounts are the number of lines that were read in each line class.
+type LineCounts struct {
+	Valid       int64
+	BadChecksum int64
+	Unknown     int64
+	Malformed   int64
+	Unsupported int64
+}
+
+// LineStats counts the lines that are read by a reader in each line class, and optionally logs
+// samples of bad lines. It is safe for concurrent use, and can be shared between the connections
+// of a source.
+type LineStats struct {
+	// Name of the source, used in logs.
+	Name string
+	// LogInterval is the minimal interval between logged samples of bad lines of each class. Zero
+	// disables logging.
+	LogInterval time.Duration
+
+	mu     sync.Mutex
+	counts LineCounts
+	// logged is the last time that a line of each class was logged.
+	logged map[LineClass]time.Time
+}
+
+// Counts returns the current line counts.
+func (s *LineStats) Counts() LineCounts {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.counts
+}
+
+// count counts a line in the given class. The error is the reason of a bad line, and may be nil.
+func (s *LineStats) count(class LineClass, line string, err error) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch class {
+	case LineValid:
+		s.counts.Valid++
+		return
+	case LineUnsupported:
+		s.counts.Unsupported++
+		return
+	case LineBadChecksum:
+		s.counts.BadChecksum++
+	case LineUnknown:
+		s.counts.Unknown++
+	case LineMalformed:
+		s.counts.Malformed++
+	}
+
+	if s.LogInterval <= 0 {
+		return
+	}
+	now := time.Now()
+	if now.Sub(s.logged[class]) < s.LogInterval {
+		return
+	}
+	if s.logged == nil {
+		s.logged = make(map[LineClass]time.Time)
+	}
+	s.logged[class] = now
+	line = strings.TrimSpace(line)
+	if err != nil {
+		log.Printf("Sample %s line from %s: %q: %v", class, s.Name, line, err)
+	} else {
+		log.Printf("Sample %s line from %s: %q", class, s.Name, line)
+	}
+}
+
+// parsedSentences are the types of the sentences that are parsed by the port, without the talker
+// ID.
+var parsedSentences = map[string]bool{
+	nmea.TypeRMC: true,
+	nmea.TypeGGA: true,
+	"FLAA":       true,
+	"FLAU":       true,
+	"FLAV":       true,
+	"FLAE":       true,
+	"FLAC":       true,
+	"GRMZ":       true,
+}
+
+// nmeaLineClass returns the class of a line that failed parsing as an NMEA sentence.
+func nmeaLineClass(line string) LineClass {
+	line = strings.TrimSpace(line)
+	i := strings.LastIndex(line, nmea.ChecksumSep)
+	if !strings.HasPrefix(line, nmea.SentenceStart) || i < 0 || len(line)-i != 3 {
+		return LineMalformed
+	}
+	prefix, _, ok := sentenceFields(line)
+	switch {
+	case !ok:
+		return LineBadChecksum
+	case parsedSentences[sentenceType(prefix)]:
+		// The sentence has invalid fields.
+		return LineMalformed
+	default:
+		return LineUnknown
+	}
+}
@@ -0,0 +1,144 @@
+package flarmport
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sync"
+)
+
+// lineServer serves text lines to multiple clients. Each client has its own queue, such that a
+// slow client does not stall the others or the sender.
+type lineServer struct {
+	// name of the served protocol, used for logging.
+	name      string
+	queueSize int
+	listener  net.Listener
+
+	mu      sync.Mutex
+	clients map[*lineClient]bool
+	done    chan struct{}
+	once    sync.Once
+}
+
+// lineClient is a consumer of the served lines.
+type lineClient struct {
+	name    string
+	conn    io.WriteCloser
+	lines   chan string
+	dropped int
+}
+
+func newLineServer(name string, queueSize int) *lineServer {
+	return &lineServer{
+		name:      name,
+		queueSize: queueSize,
+		clients:   map[*lineClient]bool{},
+		done:      make(chan struct{}),
+	}
+}
+
+// listen starts accepting TCP clients on the given address.
+func (s *lineServer) listen(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed listening on %s: %v", addr, err)
+	}
+	s.listener = l
+	go s.serve()
+	return nil
+}
+
+func (s *lineServer) serve() {
+	log.Printf("Serving %s on %s", s.name, s.listener.Addr())
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			select {
+			case <-s.done:
+			default:
+				log.Printf("Failed accepting %s client: %v", s.name, err)
+			}
+			return
+		}
+		s.add(conn.RemoteAddr().String(), conn)
+	}
+}
+
+// close stops serving and disconnects all clients.
+func (s *lineServer) close() error {
+	s.once.Do(func() { close(s.done) })
+	var err error
+	if s.listener != nil {
+		err = s.listener.Close()
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for c := range s.clients {
+		s.removeLocked(c)
+	}
+	return err
+}
+
+func (s *lineServer) add(name string, conn io.WriteCloser) {
+	c := &lineClient{
+		name:  name,
+		conn:  conn,
+		lines: make(chan string, s.queueSize),
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	select {
+	case <-s.done:
+		conn.Close()
+		return
+	default:
+	}
+	log.Printf("%s client %s connected", s.name, name)
+	s.clients[c] = true
+	go s.write(c)
+}
+
+func (s *lineServer) remove(c *lineClient) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.removeLocked(c)
+}
+
+func (s *lineServer) removeLocked(c *lineClient) {
+	if !s.clients[c] {
+		return
+	}
+	log.Printf("%s client %s disconnected", s.name, c.name)
+	delete(s.clients, c)
+	close(c.lines)
+	c.conn.Close()
+}
+
+// write writes the queued lines to the client until it is removed.
+func (s *lineServer) write(c *lineClient) {
+	for line := range c.lines {
+		_, err := io.WriteString(c.conn, line+"\r\n")
+		if err != nil {
+			s.remove(c)
+			return
+		}
+	}
+}
+
+// send queues a line to all the clients. It drops the line for clients that their queue is full.
+func (s *lineServer) send(line string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for c := range s.clients {
+		select {
+		case c.lines <- line:
+		default:
+			if c.dropped%100 == 0 {
+				log.Printf("%s client %s is slow, dropped %d lines", s.name, c.name, c.dropped+1)
+			}
+			c.dropped++
+		}
+	}
+}
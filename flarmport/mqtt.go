@@ -0,0 +1,239 @@
+package flarmport
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+const (
+	defaultMQTTTopicPrefix = "flarm"
+	defaultMQTTStation     = "station"
+	defaultMQTTQueueSize   = 256
+	mqttTimeout            = 10 * time.Second
+	mqttRetryDelay         = 30 * time.Second
+)
+
+// MQTTConfig is configuration for publishing data to an MQTT broker.
+type MQTTConfig struct {
+	// Broker address, e.g. "tcp://localhost:1883" or "ssl://broker:8883". If empty, publishing is
+	// disabled.
+	Broker string
+	// ClientID of the connection. Default is "flarm-<station>".
+	ClientID string
+	// Username and Password for authenticating with the broker.
+	Username string
+	Password string
+	// TopicPrefix is the first level of the published topics. Default is "flarm".
+	TopicPrefix string
+	// Station name, the second level of the published topics. Each aircraft is published to the
+	// topic "<prefix>/<station>/<id>". Default is "station".
+	Station string
+	// QoS of the published messages: 0, 1 or 2.
+	QoS byte
+	// TLS configuration, used for "ssl://" brokers.
+	TLS MQTTTLSConfig
+	// QueueSize is the number of messages that are queued while not connected. Default is 256.
+	QueueSize int
+}
+
+// MQTTTLSConfig is TLS configuration for connecting to an MQTT broker.
+type MQTTTLSConfig struct {
+	// CACert is a path to a PEM file of certificate authorities to verify the broker with. Default
+	// is the system pool.
+	CACert string
+	// Cert and Key are paths to PEM files of a client certificate.
+	Cert string
+	Key  string
+	// InsecureSkipVerify disables verification of the broker certificate.
+	InsecureSkipVerify bool
+}
+
+// MQTT publishes data to per-aircraft topics in an MQTT broker. The messages are retained, such
+// that subscribers get the last state of each aircraft when they subscribe.
+type MQTT struct {
+	client mqtt.Client
+	cfg    MQTTConfig
+	queue  chan mqttMessage
+	done   chan struct{}
+	once   sync.Once
+	wg     sync.WaitGroup
+}
+
+// mqttMessage is a message that is queued for publishing.
+type mqttMessage struct {
+	topic   string
+	payload []byte
+}
+
+// NewMQTT starts publishing to an MQTT broker. It returns nil if publishing is disabled in the
+// configuration. The broker is connected in the background, and the connection is retried until
+// it succeeds, such that a broker that is down does not fail the caller. After connecting, the
+// client reconnects automatically if the connection is lost.
+func NewMQTT(cfg MQTTConfig) (*MQTT, error) {
+	if cfg.Broker == "" {
+		return nil, nil
+	}
+	if cfg.QoS > 2 {
+		return nil, fmt.Errorf("invalid mqtt qos %d", cfg.QoS)
+	}
+	if cfg.TopicPrefix == "" {
+		cfg.TopicPrefix = defaultMQTTTopicPrefix
+	}
+	if cfg.Station == "" {
+		cfg.Station = defaultMQTTStation
+	}
+	if cfg.ClientID == "" {
+		cfg.ClientID = "flarm-" + cfg.Station
+	}
+	if cfg.QueueSize == 0 {
+		cfg.QueueSize = defaultMQTTQueueSize
+	}
+	tlsCfg, err := cfg.TLS.config()
+	if err != nil {
+		return nil, err
+	}
+
+	opts := mqtt.NewClientOptions().
+		AddBroker(cfg.Broker).
+		SetClientID(cfg.ClientID).
+		SetUsername(cfg.Username).
+		SetPassword(cfg.Password).
+		SetConnectTimeout(mqttTimeout).
+		SetWriteTimeout(mqttTimeout).
+		SetAutoReconnect(true).
+		SetOnConnectHandler(func(mqtt.Client) { log.Printf("Connected to mqtt broker %s", cfg.Broker) }).
+		SetConnectionLostHandler(func(_ mqtt.Client, err error) { log.Printf("Lost mqtt connection: %v", err) })
+	if tlsCfg != nil {
+		opts.SetTLSConfig(tlsCfg)
+	}
+	m := &MQTT{
+		client: mqtt.NewClient(opts),
+		cfg:    cfg,
+		queue:  make(chan mqttMessage, cfg.QueueSize),
+		done:   make(chan struct{}),
+	}
+	m.wg.Add(1)
+	go m.run()
+	return m, nil
+}
+
+// Data publishes the given data as JSON to the topic of the aircraft. The message is dropped if
+// the queue is full.
+func (m *MQTT) Data(d Data) {
+	if m == nil || d.Address == "" {
+		return
+	}
+	payload, err := json.Marshal(d)
+	if err != nil {
+		log.Printf("Failed encoding mqtt message: %v", err)
+		return
+	}
+	select {
+	case m.queue <- mqttMessage{topic: m.topic(d.Address), payload: payload}:
+	default:
+	}
+}
+
+// Close disconnects from the broker.
+func (m *MQTT) Close() error {
+	if m == nil {
+		return nil
+	}
+	m.once.Do(func() { close(m.done) })
+	m.wg.Wait()
+	m.client.Disconnect(uint(mqttTimeout / time.Millisecond))
+	return nil
+}
+
+// run connects to the broker and publishes the queued messages until closed.
+func (m *MQTT) run() {
+	defer m.wg.Done()
+	if !m.connect() {
+		return
+	}
+	for {
+		select {
+		case <-m.done:
+			return
+		case msg := <-m.queue:
+			m.publish(msg)
+		}
+	}
+}
+
+// connect connects to the broker, and retries until it succeeds. It returns false if closed
+// before connecting.
+func (m *MQTT) connect() bool {
+	for {
+		t := m.client.Connect()
+		select {
+		case <-m.done:
+			return false
+		case <-t.Done():
+		}
+		err := t.Error()
+		if err == nil {
+			return true
+		}
+		log.Printf("Failed connecting to mqtt broker %s: %v. Retrying in %s", m.cfg.Broker, err, mqttRetryDelay)
+		select {
+		case <-m.done:
+			return false
+		case <-time.After(mqttRetryDelay):
+		}
+	}
+}
+
+// publish publishes a message and waits until it is delivered.
+func (m *MQTT) publish(msg mqttMessage) {
+	t := m.client.Publish(msg.topic, m.cfg.QoS, true, msg.payload)
+	select {
+	case <-m.done:
+	case <-t.Done():
+		if err := t.Error(); err != nil {
+			log.Printf("Failed publishing to mqtt: %v", err)
+		}
+	case <-time.After(mqttTimeout):
+		log.Printf("Timeout publishing to mqtt")
+	}
+}
+
+// topic returns the topic of the given aircraft address.
+func (m *MQTT) topic(address string) string {
+	return strings.Join([]string{m.cfg.TopicPrefix, m.cfg.Station, address}, "/")
+}
+
+// config returns the TLS configuration, or nil if the default one should be used.
+func (c MQTTTLSConfig) config() (*tls.Config, error) {
+	if c.CACert == "" && c.Cert == "" && !c.InsecureSkipVerify {
+		return nil, nil
+	}
+	cfg := &tls.Config{InsecureSkipVerify: c.InsecureSkipVerify}
+	if c.CACert != "" {
+		pem, err := os.ReadFile(c.CACert)
+		if err != nil {
+			return nil, fmt.Errorf("failed reading mqtt ca cert: %v", err)
+		}
+		cfg.RootCAs = x509.NewCertPool()
+		if !cfg.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in mqtt ca cert %s", c.CACert)
+		}
+	}
+	if c.Cert != "" {
+		cert, err := tls.LoadX509KeyPair(c.Cert, c.Key)
+		if err != nil {
+			return nil, fmt.Errorf("failed loading mqtt client cert: %v", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
@@ -0,0 +1,183 @@
+package flarmport
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMQTT(t *testing.T) {
+	t.Parallel()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	published := serveMQTT(t, l)
+
+	m, err := NewMQTT(MQTTConfig{Broker: "tcp://" + l.Addr().String(), Station: "megido", QoS: 1})
+	require.NoError(t, err)
+	defer m.Close()
+
+	m.Data(Data{Kind: KindRangeOnly, Distance: 100}) // No address.
+	m.Data(Data{Kind: KindPosition, Name: "APL", Address: "DD8E8B", Lat: 32.5, Long: 35.2})
+
+	select {
+	case p := <-published:
+		assert.Equal(t, "flarm/megido/DD8E8B", p.TopicName)
+		assert.True(t, p.Retain)
+		assert.Equal(t, byte(1), p.Qos)
+		var d Data
+		require.NoError(t, json.Unmarshal(p.Payload, &d))
+		assert.Equal(t, "APL", d.Name)
+		assert.Equal(t, 32.5, d.Lat)
+	case <-time.After(5 * time.Second):
+		t.Fatal("message was not published")
+	}
+}
+
+func TestMQTTTLS(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	cert := testCert(t, dir)
+
+	l, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}})
+	require.NoError(t, err)
+	published := serveMQTT(t, l)
+
+	// Unknown certificate authority. The connection is retried in the background.
+	bad, err := NewMQTT(MQTTConfig{Broker: "ssl://" + l.Addr().String(), Station: "bad"})
+	require.NoError(t, err)
+	defer bad.Close()
+	bad.Data(Data{Kind: KindPosition, Address: "DD8E8B"})
+
+	m, err := NewMQTT(MQTTConfig{
+		Broker:   "ssl://" + l.Addr().String(),
+		ClientID: "test",
+		TLS:      MQTTTLSConfig{CACert: filepath.Join(dir, "cert.pem")},
+	})
+	require.NoError(t, err)
+	defer m.Close()
+
+	m.Data(Data{Kind: KindPosition, Address: "DD8E8B"})
+	select {
+	case p := <-published:
+		assert.Equal(t, "flarm/station/DD8E8B", p.TopicName)
+		assert.Equal(t, byte(0), p.Qos)
+	case <-time.After(5 * time.Second):
+		t.Fatal("message was not published")
+	}
+}
+
+func TestMQTTConfig(t *testing.T) {
+	t.Parallel()
+
+	m, err := NewMQTT(MQTTConfig{})
+	require.NoError(t, err)
+	assert.Nil(t, m)
+	m.Data(Data{})
+	assert.NoError(t, m.Close())
+
+	_, err = NewMQTT(MQTTConfig{Broker: "tcp://localhost:1883", QoS: 3})
+	assert.Error(t, err)
+
+	_, err = NewMQTT(MQTTConfig{Broker: "ssl://localhost:8883", TLS: MQTTTLSConfig{CACert: "/not/exist"}})
+	assert.Error(t, err)
+
+	// The broker is down.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	l.Close()
+	m, err = NewMQTT(MQTTConfig{Broker: "tcp://" + l.Addr().String(), QueueSize: 1})
+	require.NoError(t, err)
+	// Messages are dropped when the queue is full.
+	m.Data(Data{Address: "DD8E8B"})
+	m.Data(Data{Address: "DD8E8B"})
+	assert.NoError(t, m.Close())
+}
+
+// serveMQTT serves a minimal MQTT broker on the given listener, that accepts any connection and
+// returns the published messages.
+func serveMQTT(t *testing.T, l net.Listener) <-chan *packets.PublishPacket {
+	t.Cleanup(func() { l.Close() })
+	published := make(chan *packets.PublishPacket, 10)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				for {
+					p, err := packets.ReadPacket(conn)
+					if err != nil {
+						return
+					}
+					var resp packets.ControlPacket
+					switch p := p.(type) {
+					case *packets.ConnectPacket:
+						resp = packets.NewControlPacket(packets.Connack)
+					case *packets.PublishPacket:
+						published <- p
+						if p.Qos == 1 {
+							ack := packets.NewControlPacket(packets.Puback).(*packets.PubackPacket)
+							ack.MessageID = p.MessageID
+							resp = ack
+						}
+					case *packets.PingreqPacket:
+						resp = packets.NewControlPacket(packets.Pingresp)
+					case *packets.DisconnectPacket:
+						return
+					}
+					if resp != nil {
+						if err := resp.Write(conn); err != nil {
+							return
+						}
+					}
+				}
+			}()
+		}
+	}()
+	return published
+}
+
+// testCert creates a self signed certificate for 127.0.0.1, and writes it to cert.pem in the
+// given directory.
+func testCert(t *testing.T, dir string) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "cert.pem"), certPEM, 0600))
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+	cert, err := tls.X509KeyPair(certPEM, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}))
+	require.NoError(t, err)
+	return cert
+}
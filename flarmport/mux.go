@@ -0,0 +1,308 @@
+package flarmport
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/adrianmo/go-nmea"
+	"github.com/jacobsa/go-serial/serial"
+)
+
+const (
+	defaultMuxQueueSize = 256
+	defaultMuxBaudRate  = 19200
+)
+
+// MuxConfig is configuration for re-serving the NMEA stream.
+type MuxConfig struct {
+	// Addr is the TCP address to serve NMEA clients on, e.g. ":4353". If empty, TCP serving is
+	// disabled.
+	Addr string
+	// Port is an optional serial port path to write the NMEA stream to.
+	Port string
+	// BaudRate of the serial port. Default is 19200.
+	BaudRate uint
+	// Raw re-serves the raw lines that are read from the flarm port. Otherwise, the PFLAA, PFLAU
+	// and GPRMC sentences are reconstructed from the data of all the sources, relative to the
+	// receiver GPS fix, or to the station location when there is no valid fix.
+	Raw bool
+	// QueueSize is the number of lines that are queued for each client. Lines are dropped for a
+	// client that its queue is full. Default is 256.
+	QueueSize int
+}
+
+// Mux serves an NMEA stream to multiple TCP clients, such as navigation apps, and optionally to a
+// serial port. Each client has its own queue, such that a slow client does not stall the others or
+// the reader.
+type Mux struct {
+	*lineServer
+	cfg     MuxConfig
+	station StationInfo
+	// fix is the GPS fix of the flarm receiver, from the recorded lines, guarded by fixMu.
+	fix   fix
+	fixMu sync.Mutex
+}
+
+// NewMux starts serving an NMEA stream. It returns nil if serving is disabled in the
+// configuration.
+func NewMux(cfg MuxConfig, station StationInfo) (*Mux, error) {
+	if cfg.Addr == "" && cfg.Port == "" {
+		return nil, nil
+	}
+	if cfg.QueueSize == 0 {
+		cfg.QueueSize = defaultMuxQueueSize
+	}
+	if cfg.BaudRate == 0 {
+		cfg.BaudRate = defaultMuxBaudRate
+	}
+	m := &Mux{
+		lineServer: newLineServer("NMEA", cfg.QueueSize),
+		cfg:        cfg,
+		station:    station,
+	}
+
+	if cfg.Port != "" {
+		port, err := serial.Open(serial.OpenOptions{
+			PortName:        cfg.Port,
+			BaudRate:        cfg.BaudRate,
+			MinimumReadSize: 1,
+			StopBits:        1,
+			DataBits:        8,
+			ParityMode:      serial.PARITY_NONE,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed open serial port: %v", err)
+		}
+		m.add(cfg.Port, port)
+	}
+
+	if cfg.Addr != "" {
+		err := m.listen(cfg.Addr)
+		if err != nil {
+			m.Close()
+			return nil, err
+		}
+	}
+
+	if !cfg.Raw {
+		go m.sendFix()
+	}
+	return m, nil
+}
+
+// Record re-serves a raw line in raw mode. In reconstructed mode, it tracks the receiver GPS fix
+// from the line, and re-serves the GPS sentences of a valid fix.
+func (m *Mux) Record(line string) {
+	if m == nil {
+		return
+	}
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return
+	}
+	if m.cfg.Raw {
+		m.send(line)
+		return
+	}
+	value, err := nmea.Parse(line)
+	if err != nil {
+		// A GPS sentence without a position fix might fail parsing.
+		m.fixMu.Lock()
+		m.fix.updateRaw(line)
+		m.fixMu.Unlock()
+		return
+	}
+	m.fixMu.Lock()
+	switch e := value.(type) {
+	case nmea.RMC:
+		m.fix.updateRMC(e)
+	case nmea.GGA:
+		m.fix.updateGGA(e)
+	default:
+		m.fixMu.Unlock()
+		return
+	}
+	valid := m.fix.valid
+	m.fixMu.Unlock()
+	if valid {
+		m.send(line)
+	}
+}
+
+// location returns the reference location of the reconstructed sentences, and whether it is the
+// receiver GPS fix.
+func (m *Mux) location() (lat, long, alt float64, live bool) {
+	m.fixMu.Lock()
+	defer m.fixMu.Unlock()
+	live = m.fix.valid && time.Since(m.fix.updated) <= fixTimeout
+	lat, long, alt = m.fix.location(m.station)
+	return lat, long, alt, live
+}
+
+// Data serves a reconstructed PFLAA sentence of the given data.
+func (m *Mux) Data(d Data) {
+	if m == nil || m.cfg.Raw {
+		return
+	}
+	lat, long, alt, _ := m.location()
+	if line, ok := formatPFLAA(d, lat, long, alt); ok {
+		m.send(line)
+	}
+}
+
+// Status serves a reconstructed PFLAU sentence of the given status.
+func (m *Mux) Status(s Status) {
+	if m == nil || m.cfg.Raw {
+		return
+	}
+	m.send(formatPFLAU(s))
+}
+
+// Close stops serving and disconnects all clients.
+func (m *Mux) Close() error {
+	if m == nil {
+		return nil
+	}
+	return m.close()
+}
+
+// sendFix sends the station location as the GPS fix every second in reconstructed mode, when the
+// GPS sentences of the receiver are not available.
+func (m *Mux) sendFix() {
+	t := time.NewTicker(time.Second)
+	defer t.Stop()
+	for {
+		select {
+		case <-m.done:
+			return
+		case now := <-t.C:
+			if _, _, _, live := m.location(); live {
+				continue
+			}
+			m.send(formatGPRMC(now, m.station))
+			m.send(formatGPGGA(now, m.station))
+		}
+	}
+}
+
+// formatPFLAA returns a PFLAA sentence of the given data, relative to the given location. It
+// returns false if the data can't be represented.
+func formatPFLAA(d Data, lat, long, alt float64) (string, bool) {
+	const maxRel = 32767
+	if d.Kind == KindRangeOnly {
+		if math.Abs(d.Distance) > maxRel {
+			return "", false
+		}
+		return nmeaSentence("PFLAA", d.AlarmLevel, math.Round(d.Distance), "", math.Round(d.RelativeAlt),
+			"", d.Address, "", "", "", "", aircraftTypeCode(d.Type)), true
+	}
+	if d.Address == "" {
+		return "", false
+	}
+	north, east := relative(lat, long, d.Lat, d.Long)
+	vertical := d.Alt - alt
+	if math.Abs(north) > maxRel || math.Abs(east) > maxRel || math.Abs(vertical) > maxRel {
+		return "", false
+	}
+	return nmeaSentence("PFLAA", d.AlarmLevel, math.Round(north), math.Round(east), math.Round(vertical),
+		idTypeCode(d.AddressType), d.Address, d.Dir, "", d.GroundSpeed, fmt.Sprintf("%.1f", d.Climb),
+		aircraftTypeCode(d.Type)), true
+}
+
+// formatPFLAU returns a PFLAU sentence of the given status.
+func formatPFLAU(s Status) string {
+	var bearing, vertical, distance interface{} = "", "", ""
+	if s.Intruder != nil {
+		bearing, vertical, distance = s.Intruder.RelativeBearing, s.Intruder.RelativeVertical, s.Intruder.RelativeDistance
+	}
+	power := 0
+	if s.Power {
+		power = 1
+	}
+	return nmeaSentence("PFLAU", s.Rx, code(s.Tx, tx, 1), code(s.GPS, gpsStatus, 2), power, s.AlarmLevel,
+		bearing, alarmTypeCode(s.AlarmType), vertical, distance, "")
+}
+
+// formatGPRMC returns a GPRMC sentence with the station location.
+func formatGPRMC(t time.Time, station StationInfo) string {
+	t = t.UTC()
+	lat, ns, long, ew := nmeaCoordinates(station.Lat, station.Long)
+	return nmeaSentence("GPRMC", t.Format("150405.00"), "A", lat, ns, long, ew, "0.0", "0.0",
+		t.Format("020106"), "", "", "A")
+}
+
+// formatGPGGA returns a GPGGA sentence with the station location and altitude.
+func formatGPGGA(t time.Time, station StationInfo) string {
+	lat, ns, long, ew := nmeaCoordinates(station.Lat, station.Long)
+	return nmeaSentence("GPGGA", t.UTC().Format("150405.00"), lat, ns, long, ew, 1, 8, "1.0",
+		fmt.Sprintf("%.1f", station.Alt), "M", "0.0", "M", "", "")
+}
+
+// nmeaSentence formats an NMEA sentence with the given fields and appends its checksum.
+func nmeaSentence(typ string, fields ...interface{}) string {
+	parts := []string{typ}
+	for _, f := range fields {
+		parts = append(parts, fmt.Sprint(f))
+	}
+	body := strings.Join(parts, ",")
+	var checksum byte
+	for i := 0; i < len(body); i++ {
+		checksum ^= body[i]
+	}
+	return fmt.Sprintf("$%s*%02X", body, checksum)
+}
+
+// nmeaCoordinates formats coordinates as NMEA degrees and minutes.
+func nmeaCoordinates(lat, long float64) (string, string, string, string) {
+	ns, ew := "N", "E"
+	if lat < 0 {
+		ns, lat = "S", -lat
+	}
+	if long < 0 {
+		ew, long = "W", -long
+	}
+	latDeg, longDeg := math.Floor(lat), math.Floor(long)
+	return fmt.Sprintf("%02.0f%08.5f", latDeg, (lat-latDeg)*60), ns,
+		fmt.Sprintf("%03.0f%08.5f", longDeg, (long-longDeg)*60), ew
+}
+
+// relative returns the relative position in meters north and east of a point from an origin. It
+// is the inverse of add.
+func relative(lat0, lon0, lat, lon float64) (float64, float64) {
+	const earthRadius = 6378137
+	north := (lat - lat0) * math.Pi / 180 * earthRadius
+	east := (lon - lon0) * math.Pi / 180 * earthRadius * math.Cos(math.Pi*lat0/180.0)
+	return north, east
+}
+
+// code returns the numeric code of a value that was parsed by the given function.
+func code(v string, parse func(int64) string, max int64) int64 {
+	for i := int64(0); i <= max; i++ {
+		if parse(i) == v {
+			return i
+		}
+	}
+	return 0
+}
+
+func idTypeCode(addressType string) string {
+	switch addressType {
+	case "official":
+		return "1"
+	case "anonymous":
+		return "3"
+	}
+	return "2"
+}
+
+func alarmTypeCode(v string) string {
+	for _, c := range []string{"2", "3"} {
+		if alarmType(c) == v {
+			return c
+		}
+	}
+	return "0"
+}
@@ -0,0 +1,171 @@
+package flarmport
+
+import (
+	"bufio"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/adrianmo/go-nmea"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMuxReconstructed(t *testing.T) {
+	t.Parallel()
+
+	station := StationInfo{Lat: 32.5, Long: 35.2, Alt: 50}
+	m, err := NewMux(MuxConfig{Addr: "127.0.0.1:0"}, station)
+	require.NoError(t, err)
+	defer m.Close()
+
+	r := connectMux(t, m)
+
+	lat, long := add(station.Lat, station.Long, -1388, -330)
+	m.Data(Data{
+		Kind:        KindPosition,
+		Address:     "DD8E8B",
+		AddressType: "flarm id",
+		Lat:         lat,
+		Long:        long,
+		Alt:         515,
+		Dir:         78,
+		GroundSpeed: 44,
+		Climb:       2.8,
+		Type:        "towplane",
+	})
+	m.Data(Data{Kind: KindRangeOnly, Distance: 1500, RelativeAlt: -100})
+	// Too far to be represented.
+	m.Data(Data{Kind: KindPosition, Address: "AAAAAA", Lat: station.Lat + 1, Long: station.Long})
+	m.Status(Status{Rx: 2, Tx: "OK", GPS: "valid airborne", Power: true, AlarmType: "no alarm"})
+
+	assert.Equal(t, "$PFLAA,0,-1388,-330,465,2,DD8E8B,78,,44,2.8,2*6F", readSentence(t, r, "PFLAA"))
+
+	got, err := nmea.Parse(readSentence(t, r, "PFLAA"))
+	require.NoError(t, err)
+	pflaa := got.(TypePFLAA)
+	assert.True(t, pflaa.NonDirectional())
+	assert.Equal(t, int64(1500), pflaa.RelativeNorth)
+	assert.Equal(t, int64(-100), pflaa.RelativeVertical)
+
+	got, err = nmea.Parse(readSentence(t, r, "PFLAU"))
+	require.NoError(t, err)
+	pflau := got.(TypePFLAU)
+	assert.Equal(t, int64(2), pflau.Rx)
+	assert.Equal(t, "OK", pflau.Tx)
+	assert.Equal(t, "valid airborne", pflau.GPS)
+	assert.Equal(t, int64(1), pflau.Power)
+
+	got, err = nmea.Parse(readSentence(t, r, "GPRMC"))
+	require.NoError(t, err)
+	rmc := got.(nmea.RMC)
+	assert.InDelta(t, station.Lat, rmc.Latitude, 1e-6)
+	assert.InDelta(t, station.Long, rmc.Longitude, 1e-6)
+}
+
+func TestMuxReceiverFix(t *testing.T) {
+	t.Parallel()
+
+	station := StationInfo{Lat: 32.5, Long: 35.2, Alt: 50}
+	m, err := NewMux(MuxConfig{Addr: "127.0.0.1:0"}, station)
+	require.NoError(t, err)
+	defer m.Close()
+
+	r := connectMux(t, m)
+
+	// The GPS sentences of a moving receiver are re-served.
+	rmc := "$GPRMC,123536.00,A,3235.79217,N,03514.10416,E,0.006,,171220,,,A*7D"
+	m.Record(rmc)
+	m.Record("$GPGGA,123536.00,3235.79217,N,03514.10416,E,1,06,1.22,52.3,M,18.0,M,,*6A")
+	assert.Equal(t, rmc, readSentence(t, r, "GPRMC"))
+
+	// Data is relative to the receiver fix.
+	lat, long := add(32.5965362, 35.2350693, 100, -200)
+	m.Data(Data{Kind: KindPosition, Address: "DD8E8B", AddressType: "flarm id", Lat: lat, Long: long, Alt: 152.3})
+	got, err := nmea.Parse(readSentence(t, r, "PFLAA"))
+	require.NoError(t, err)
+	pflaa := got.(TypePFLAA)
+	assert.Equal(t, int64(100), pflaa.RelativeNorth)
+	assert.Equal(t, int64(-200), pflaa.RelativeEast)
+	assert.Equal(t, int64(100), pflaa.RelativeVertical)
+}
+
+func TestMuxRaw(t *testing.T) {
+	t.Parallel()
+
+	m, err := NewMux(MuxConfig{Addr: "127.0.0.1:0", Raw: true}, StationInfo{})
+	require.NoError(t, err)
+	defer m.Close()
+
+	r := connectMux(t, m)
+
+	// Reconstructed sentences are not sent in raw mode.
+	m.Status(Status{})
+	m.Record("\n$PFLAU,2,1,1,1,0,,0,,*61")
+
+	line, err := r.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "$PFLAU,2,1,1,1,0,,0,,*61\r\n", line)
+}
+
+func TestMuxSlowClient(t *testing.T) {
+	t.Parallel()
+
+	m, err := NewMux(MuxConfig{Addr: "127.0.0.1:0", Raw: true, QueueSize: 1}, StationInfo{})
+	require.NoError(t, err)
+	defer m.Close()
+
+	// A client that does not read.
+	connectMux(t, m)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100000; i++ {
+			m.Record("$PFLAU,2,1,1,1,0,,0,,*61")
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Slow client stalled the mux")
+	}
+}
+
+func TestMuxDisabled(t *testing.T) {
+	t.Parallel()
+
+	m, err := NewMux(MuxConfig{}, StationInfo{})
+	require.NoError(t, err)
+	assert.Nil(t, m)
+	// Disabled mux can be used.
+	m.Record("line")
+	m.Data(Data{})
+	m.Status(Status{})
+	assert.NoError(t, m.Close())
+}
+
+// connectMux connects a client to the mux and waits until it is registered.
+func connectMux(t *testing.T, m *Mux) *bufio.Reader {
+	conn, err := net.Dial("tcp", m.listener.Addr().String())
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	require.Eventually(t, func() bool {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		return len(m.clients) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	return bufio.NewReader(conn)
+}
+
+// readSentence reads sentences until a sentence of the given type is read.
+func readSentence(t *testing.T, r *bufio.Reader, typ string) string {
+	for {
+		line, err := r.ReadString('\n')
+		require.NoError(t, err)
+		if len(line) > len(typ) && line[1:len(typ)+1] == typ {
+			return line[:len(line)-2]
+		}
+	}
+}
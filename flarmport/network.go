@@ -0,0 +1,66 @@
+package flarmport
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// OpenNetwork opens a network connection to a FLARM NMEA stream, such as a WiFi/Ethernet serial
+// bridge or an XCSoar-style TCP NMEA port. The address is either "tcp://host:port" (or just
+// "host:port") to connect to a TCP server, or "udp://host:port" to listen for UDP datagrams on a
+// local address. Host names are resolved.
+func OpenNetwork(addr string, station StationInfo) (*Port, error) {
+	network, hostPort := "tcp", addr
+	if i := strings.Index(addr, "://"); i >= 0 {
+		network, hostPort = addr[:i], addr[i+3:]
+	}
+
+	switch network {
+	case "tcp":
+		conn, err := net.DialTimeout("tcp", hostPort, time.Second*10)
+		if err != nil {
+			return nil, fmt.Errorf("failed connecting to %s: %v", addr, err)
+		}
+		return newPort(conn, station), nil
+	case "udp":
+		udpAddr, err := net.ResolveUDPAddr("udp", hostPort)
+		if err != nil {
+			return nil, fmt.Errorf("failed resolving %s: %v", addr, err)
+		}
+		conn, err := net.ListenUDP("udp", udpAddr)
+		if err != nil {
+			return nil, fmt.Errorf("failed listening on %s: %v", addr, err)
+		}
+		return newPort(&datagramConn{UDPConn: conn, buf: make([]byte, 65536+1)}, station), nil
+	default:
+		return nil, fmt.Errorf("unsupported network %q, expected tcp or udp", network)
+	}
+}
+
+// datagramConn reads whole datagrams from a UDP connection, such that a datagram is not truncated
+// when it is read into a smaller buffer. Each datagram ends a line.
+type datagramConn struct {
+	*net.UDPConn
+	buf []byte
+	// pending is the unread data of the last datagram.
+	pending []byte
+}
+
+func (c *datagramConn) Read(b []byte) (int, error) {
+	for len(c.pending) == 0 {
+		// Leave room for a line terminator.
+		n, _, err := c.ReadFrom(c.buf[:len(c.buf)-1])
+		if err != nil {
+			return 0, err
+		}
+		c.pending = c.buf[:n]
+		if n > 0 && c.buf[n-1] != '\r' && c.buf[n-1] != '\n' {
+			c.pending = append(c.pending, '\r')
+		}
+	}
+	n := copy(b, c.pending)
+	c.pending = c.pending[n:]
+	return n, nil
+}
@@ -0,0 +1,89 @@
+package flarmport
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testNetworkNMEA = "$PFLAU,2,1,1,1,0,,0,,*61\r\n$PFLAA,0,-1388,-330,465,2,DD8E8B,78,,44,2.8,2*6F\r\n"
+
+func TestOpenNetworkTCP(t *testing.T) {
+	t.Parallel()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.Write([]byte(testNetworkNMEA))
+	}()
+
+	_, port, err := net.SplitHostPort(l.Addr().String())
+	require.NoError(t, err)
+
+	// Connect with a host name.
+	p, err := OpenNetwork("tcp://localhost:"+port, StationInfo{})
+	require.NoError(t, err)
+	defer p.Close()
+
+	assertNetworkNMEA(t, p)
+}
+
+func TestOpenNetworkUDP(t *testing.T) {
+	t.Parallel()
+
+	p, err := OpenNetwork("udp://127.0.0.1:0", StationInfo{})
+	require.NoError(t, err)
+	defer p.Close()
+
+	conn, err := net.Dial("udp", p.Closer.(net.Conn).LocalAddr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte(testNetworkNMEA))
+	require.NoError(t, err)
+
+	assertNetworkNMEA(t, p)
+
+	// Datagrams that are larger than the scanner read size, and datagrams without a line
+	// terminator, are read whole.
+	long := strings.Repeat("$PFLAU,2,1,1,1,0,,0,,*61\r\n", 200)
+	_, err = conn.Write([]byte(long))
+	require.NoError(t, err)
+	_, err = conn.Write([]byte("$PFLAA,0,-1388,-330,465,2,DD8E8B,78,,44,2.8,2*6F"))
+	require.NoError(t, err)
+	for i := 0; i < 200; i++ {
+		got, ok := p.next()
+		require.True(t, ok)
+		require.IsType(t, &Status{}, got)
+	}
+	got, ok := p.next()
+	require.True(t, ok)
+	require.IsType(t, &Data{}, got)
+}
+
+func TestOpenNetworkInvalid(t *testing.T) {
+	t.Parallel()
+
+	_, err := OpenNetwork("serial:///dev/ttyS0", StationInfo{})
+	assert.Error(t, err)
+}
+
+func assertNetworkNMEA(t *testing.T, p *Port) {
+	got, ok := p.next()
+	require.True(t, ok)
+	assert.IsType(t, &Status{}, got)
+
+	got, ok = p.next()
+	require.True(t, ok)
+	require.IsType(t, &Data{}, got)
+	assert.Equal(t, "DD8E8B", got.(*Data).Address)
+}
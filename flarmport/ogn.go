@@ -5,36 +5,64 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"math"
 	"net"
 	"regexp"
 	"strconv"
+	"strings"
 	"time"
 )
 
-// var pattern = regexp.MustCompile(`
-//     (?P<pps_offset>\d\.\d+)sec:(?P<frequency>\d+\.\d+)MHz:\s+
-//     (?P<aircraft_type>\d):(?P<address_type>\d):(?P<address>[A-F0-9]{6})\s
-//     (?P<timestamp>\d{6}):\s
-//     \[\s*(?P<latitude>[+-]\d+\.\d+),\s*(?P<longitude>[+-]\d+\.\d+)\]deg\s*
-//     (?P<altitude>\d+)m\s*
-//     (?P<climb_rate>[+-]\d+\.\d+)m/s\s*
-//     (?P<ground_speed>\d+\.\d+)m/s\s*
-//     (?P<track>\d+\.\d+)deg\s*
-//     (?P<turn_rate>[+-]\d+\.\d+)deg/sec\s*
-//     (?P<magic_number>\d+)\s*
-//     (?P<gps_status>[0-9x]+)m\s*
-//     (?P<channel>\d+)(?P<flarm_timeslot>[f_])(?P<ogn_timeslot>[o_])\s*
-//     (?P<frequency_offset>[+-]\d+\.\d+)kHz\s*
-//     (?P<decode_quality>\d+\.\d+)/(?P<signal_quality>\d+\.\d+)dB/(?P<demodulator_type>\d+)\s+
-//     (?P<error_count>\d+)e\s*
-//     (?P<distance>\d+\.\d+)km\s*
-//     (?P<bearing>\d+\.\d+)deg\s*
-//     (?P<phi>[+-]\d+\.\d+)deg\s*
-//     (?P<multichannel>\+)?\s*
-//     \?\s*
-//     R?\s*
-//     (B(?P<baro_altitude>\d+))?
-// 	`)
+// OGNConfig is configuration for reading OGN receptions.
+type OGNConfig struct {
+	// MinSNR is the minimal signal to noise ratio of a reception, in dB. Receptions with lower
+	// SNR are dropped. Zero disables the check.
+	MinSNR float64
+	// MaxErrors is the maximal number of corrected bit errors of a reception. Receptions with
+	// more errors are dropped. Zero disables the check.
+	MaxErrors int
+	// MaxFrequencyOffset is the maximal absolute frequency offset of a reception, in kHz.
+	// Receptions with larger offset are dropped. Zero disables the check.
+	MaxFrequencyOffset float64
+}
+
+// accept returns whether a reception passes the configured quality thresholds.
+func (c OGNConfig) accept(r *Reception) bool {
+	switch {
+	case c.MinSNR != 0 && r.SNR < c.MinSNR:
+		return false
+	case c.MaxErrors != 0 && r.Errors > c.MaxErrors:
+		return false
+	case c.MaxFrequencyOffset != 0 && math.Abs(r.FrequencyOffset) > c.MaxFrequencyOffset:
+		return false
+	}
+	return true
+}
+
+// Reception is the quality of an OGN reception, as reported by ogn-decode.
+type Reception struct {
+	// Time offset of the reception from the PPS, in seconds.
+	PPSOffset float64
+	// Frequency of the reception in MHz.
+	Frequency float64
+	// Frequency offset of the reception in kHz.
+	FrequencyOffset float64
+	// Horizontal and vertical accuracy of the transmitter GPS, in m.
+	HorizontalAccuracy, VerticalAccuracy int
+	// Signal to noise ratio of the reception, in dB.
+	SNR float64
+	// Signal strength of the reception, in dB.
+	Signal float64
+	// Number of corrected bit errors.
+	Errors int
+	// Distance in m, bearing in degrees and elevation angle in degrees of the transmitter from the
+	// receiver.
+	Distance, Bearing, Elevation float64
+	// Multichannel is true if the packet was received in more than one channel.
+	Multichannel bool
+	// BaroAlt is the barometric altitude in m, if reported by the transmitter.
+	BaroAlt *float64 `json:",omitempty"`
+}
 
 // OGN is a connection to a OGN port.
 // Specification at: http://wiki.glidernet.org/wiki:manual-installation-guide.
@@ -42,9 +70,14 @@ type OGN struct {
 	scanner *bufio.Scanner
 	io.Closer
 	station StationInfo
+	cfg     OGNConfig
+	// recorders record the raw lines.
+	recorders []LineRecorder
+	// stats counts the read lines. It may be nil.
+	stats *LineStats
 }
 
-func OpenOGN(addr string, station StationInfo) (*OGN, error) {
+func OpenOGN(addr string, station StationInfo, cfg OGNConfig) (*OGN, error) {
 	host, port, err := net.SplitHostPort(addr)
 	if err != nil {
 		return nil, err
@@ -61,6 +94,11 @@ func OpenOGN(addr string, station StationInfo) (*OGN, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed connecting to ogn: %v", err)
 	}
+	return newOGN(conn, station, cfg), nil
+}
+
+// newOGN returns an OGN that reads ogn-decode lines from the given connection.
+func newOGN(conn io.ReadCloser, station StationInfo, cfg OGNConfig) *OGN {
 	// Create a scanner that splits on CR.
 	s := bufio.NewScanner(conn)
 
@@ -72,18 +110,25 @@ func OpenOGN(addr string, station StationInfo) (*OGN, error) {
 		scanner: s,
 		Closer:  conn,
 		station: station,
-	}, nil
+		cfg:     cfg,
+	}
 }
 
-// Range iterates and parses data from the serial connection. It exists when the port is closed.
+// Range iterates over the positions of other aircraft. See RangeHandler for reading all values.
 func (o *OGN) Range(ctx context.Context, f func(Data)) error {
+	return o.RangeHandler(ctx, Handler{Data: f})
+}
+
+// RangeHandler iterates and parses data from the serial connection. It exists when the port is
+// closed.
+func (o *OGN) RangeHandler(ctx context.Context, h Handler) error {
 	for ctx.Err() == nil {
 		value, ok := o.next()
 		if !ok {
 			return nil
 		}
-		if value != nil && ctx.Err() == nil {
-			f(*value)
+		if ctx.Err() == nil {
+			h.handle(value)
 		}
 	}
 	return ctx.Err()
@@ -91,18 +136,55 @@ func (o *OGN) Range(ctx context.Context, f func(Data)) error {
 
 var pattern = regexp.MustCompile(`(\d+\.\d+)sec:(\d+\.\d+)MHz:\s+(\d+):(\d+):([A-F0-9]+)\s+(\d+):\s+\[\s*([+-]\d+\.\d+),\s*([+-]\d+\.\d+)\]deg\s+(\d+)m\s+([+-]\d+\.\d+)m\/s\s+(\d+.\d+)m\/s\s+(\d+\.\d+)deg\s+([+-]\d+\.\d+)deg`)
 
-// next used by Range and exist for testing purposes.
+// receptionPattern matches the reception part of the line that follows the motion part. For
+// example: `1m1 07x05m Fn:35___ +0.41kHz 45.0/55.5dB/0  0e     0.0km 161.7deg +12.9deg + B1234`.
+// Groups: 1-2: GPS accuracy, 3: frequency offset, 4: SNR, 5: signal, 6: errors, 7: distance,
+// 8: bearing, 9: elevation, 10: flags.
+var receptionPattern = regexp.MustCompile(`\s(\d+)x(\d+)m\s+\S+\s+([+-]\d+\.\d+)kHz\s+(\d+\.\d+)\/(\d+\.\d+)dB\/\d+\s+(\d+)e\s+(\d+\.\d+)km\s+(\d+\.\d+)deg\s+([+-]\d+\.\d+)deg(.*)$`)
+
+// receptionLinePattern matches the beginning of a reception line, which is used to tell apart
+// malformed reception lines from other lines of ogn-decode.
+var receptionLinePattern = regexp.MustCompile(`^\s*\d+\.\d+sec:\d+\.\d+MHz:`)
+
+// RecordTo records the raw lines that are read from the connection.
+func (o *OGN) RecordTo(r LineRecorder) {
+	o.recorders = append(o.recorders, r)
+}
+
+// CountTo counts the lines that are read from the connection in the given stats.
+func (o *OGN) CountTo(s *LineStats) {
+	o.stats = s
+}
+
+// next used by Range and exist for testing purposes. It returns nil data for lines that are not
+// aircraft receptions, and returns false only when the connection ends.
 func (o *OGN) next() (*Data, bool) {
 	if !o.scanner.Scan() {
 		// Stop scanning.
 		return nil, false
 	}
-	matches := pattern.FindStringSubmatch(o.scanner.Text())
+	line := o.scanner.Text()
+	for _, r := range o.recorders {
+		r.Record(line)
+	}
+	matches := pattern.FindStringSubmatch(line)
 	if len(matches) < 12 {
-		return nil, false
+		switch {
+		case strings.TrimSpace(line) == "":
+		case receptionLinePattern.MatchString(line):
+			o.stats.count(LineMalformed, line, nil)
+		default:
+			// Informational lines of ogn-decode.
+			o.stats.count(LineUnsupported, line, nil)
+		}
+		return nil, true
+	}
+	o.stats.count(LineValid, line, nil)
+	r := parseReception(matches, line[len(matches[0]):])
+	if r != nil && !o.cfg.accept(r) {
+		return nil, true
 	}
 	tp := aircraftType(matches[3])
-	name := o.station.MapID(matches[5])
 	lat, _ := strconv.ParseFloat(matches[7], 64)
 	long, _ := strconv.ParseFloat(matches[8], 64)
 	alt, _ := strconv.ParseFloat(matches[9], 64)
@@ -110,10 +192,15 @@ func (o *OGN) next() (*Data, bool) {
 	gs, _ := strconv.ParseFloat(matches[11], 64)
 	dir, _ := strconv.ParseFloat(matches[12], 64)
 	tr, _ := strconv.ParseFloat(matches[13], 64)
+	t, ok := o.station.timestamp(timeOfDay(matches[6], time.Now()))
+	if !ok {
+		return nil, true
+	}
 
-	return &Data{
+	d := &Data{
+		Kind:        KindPosition,
+		Source:      SourceOGN,
 		Type:        tp,
-		Name:        name,
 		Lat:         lat,
 		Long:        long,
 		Alt:         alt,
@@ -121,6 +208,63 @@ func (o *OGN) next() (*Data, bool) {
 		GroundSpeed: int64(gs),
 		Dir:         int(dir),
 		TurnRate:    tr,
-		Time:        time.Now().In(o.station.TimeZone),
-	}, true
+		Time:        t,
+		Reception:   r,
+	}
+	o.station.identify(d, matches[5], ognAddressType(matches[4]))
+	return d, true
+}
+
+// parseReception parses the reception part of an ogn-decode line, given the matches of the line
+// pattern and the rest of the line. It returns nil if the line has no reception part.
+func parseReception(matches []string, rest string) *Reception {
+	rm := receptionPattern.FindStringSubmatch(rest)
+	if len(rm) < 11 {
+		return nil
+	}
+	var r Reception
+	r.PPSOffset, _ = strconv.ParseFloat(matches[1], 64)
+	r.Frequency, _ = strconv.ParseFloat(matches[2], 64)
+	r.HorizontalAccuracy, _ = strconv.Atoi(rm[1])
+	r.VerticalAccuracy, _ = strconv.Atoi(rm[2])
+	r.FrequencyOffset, _ = strconv.ParseFloat(rm[3], 64)
+	r.SNR, _ = strconv.ParseFloat(rm[4], 64)
+	r.Signal, _ = strconv.ParseFloat(rm[5], 64)
+	r.Errors, _ = strconv.Atoi(rm[6])
+	distance, _ := strconv.ParseFloat(rm[7], 64)
+	r.Distance = distance * 1000
+	r.Bearing, _ = strconv.ParseFloat(rm[8], 64)
+	r.Elevation, _ = strconv.ParseFloat(rm[9], 64)
+
+	// Optional flags.
+	for _, flag := range strings.Fields(rm[10]) {
+		switch {
+		case flag == "+":
+			r.Multichannel = true
+		case strings.HasPrefix(flag, "B"):
+			if alt, err := strconv.ParseFloat(flag[1:], 64); err == nil {
+				r.BaroAlt = &alt
+			}
+		}
+	}
+	return &r
+}
+
+// timeOfDay returns the UTC time of a given HHMMSS time of day, that is closest to the given
+// time. It returns zero time if the given time of day is invalid.
+func timeOfDay(hhmmss string, now time.Time) time.Time {
+	tod, err := time.Parse("150405", hhmmss)
+	if err != nil {
+		return time.Time{}
+	}
+	now = now.UTC()
+	t := time.Date(now.Year(), now.Month(), now.Day(), tod.Hour(), tod.Minute(), tod.Second(), 0, time.UTC)
+	// Handle day rollover.
+	switch diff := t.Sub(now); {
+	case diff > 12*time.Hour:
+		t = t.AddDate(0, 0, -1)
+	case diff < -12*time.Hour:
+		t = t.AddDate(0, 0, 1)
+	}
+	return t
 }
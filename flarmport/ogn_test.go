@@ -3,7 +3,9 @@ package flarmport
 import (
 	"bufio"
 	"bytes"
+	"io"
 	"net"
+	"strings"
 	"testing"
 	"time"
 
@@ -17,6 +19,8 @@ Connection closed by foreign host.
 @@@ 0 user(s) and 0 logger(s) connected (plus you)
 0.802sec:916.200MHz:   1:2:DDFD1D 104436: [ +32.59657, +35.23525]deg    77m  +0.1m/s   0.4m/s 123.1deg  -1.2deg/s 1m1 07x05m Fn:35___ +0.41kHz 45.0/55.5dB/0  0e     0.0km 161.7deg +12.9deg          
 0.802sec:916.200MHz: 2:2:123456 104436: [+32.5, +35.1]deg 10m -3.1m/s 0.4m/s 123.1deg -1.2deg/s
+0.485sec:916.199MHz:   2:2:DDFD21 104436: [ +32.58487, +35.24666]deg   411m  -3.0m/s  43.2m/s 087.4deg  -0.5deg/s 2m5 05x06m Fn:35_o_ -1.32kHz 10.8/20.0dB/0  0e     1.7km 140.7deg +11.1deg          
+0.885sec:916.199MHz:   2:2:DDFD21 104521: [ +32.59388, +35.25591]deg   218m  -5.4m/s  32.2m/s 344.7deg  -0.9deg/s 2m5 05x06m Fn:35f__ -1.20kHz 26.8/37.5dB/0  2e     2.0km 099.2deg  +4.2deg  + B225
 `
 
 func TestOGN(t *testing.T) {
@@ -40,43 +44,162 @@ func TestOGN(t *testing.T) {
 		require.NoError(t, s.Err())
 	}()
 
-	ogn, err := OpenOGN(l.Addr().String(), StationInfo{IDMap: map[string]string{"123456": "4X-APL"}})
+	ogn, err := OpenOGN(l.Addr().String(), StationInfo{IDMap: map[string]Aircraft{"123456": {Name: "APL", Registration: "4X-APL"}}}, OGNConfig{MinSNR: 20})
 	require.NoError(t, err)
 
+	stats := &LineStats{Name: SourceOGN}
+	ogn.CountTo(stats)
+
+	// Lines that are not aircraft receptions are skipped.
 	for i := 0; i < 4; i++ {
-		_, ok := ogn.next()
-		assert.False(t, ok)
+		got, ok := ogn.next()
+		assert.True(t, ok)
+		assert.Nil(t, got)
 	}
 
 	// First line of data
 	got, ok := ogn.next()
 	want := &Data{
-		Type:     "glider",
-		Name:     "DDFD1D",
-		Lat:      32.59657,
-		Long:     35.23525,
-		Alt:      77,
-		Climb:    0.1,
-		Dir:      123,
-		TurnRate: -1.2,
+		Kind:        KindPosition,
+		Source:      SourceOGN,
+		Type:        "glider",
+		Name:        "DDFD1D",
+		Address:     "DDFD1D",
+		AddressType: "flarm id",
+		Lat:         32.59657,
+		Long:        35.23525,
+		Alt:         77,
+		Climb:       0.1,
+		Dir:         123,
+		TurnRate:    -1.2,
+		Reception: &Reception{
+			PPSOffset:          0.802,
+			Frequency:          916.2,
+			FrequencyOffset:    0.41,
+			HorizontalAccuracy: 7,
+			VerticalAccuracy:   5,
+			SNR:                45,
+			Signal:             55.5,
+			Bearing:            161.7,
+			Elevation:          12.9,
+		},
 	}
 	assert.True(t, ok)
+	assert.Equal(t, "10:44:36", got.Time.UTC().Format("15:04:05"))
 	got.Time = time.Time{} // Clear time before comparing.
 	assert.Equal(t, want, got)
 
 	// Second line of data
 	got, ok = ogn.next()
 	want = &Data{
-		Type:     "towplane",
-		Name:     "4X-APL",
-		Lat:      32.5,
-		Long:     35.1,
-		Alt:      10,
-		Climb:    -3.1,
-		Dir:      123,
-		TurnRate: -1.2,
+		Kind:         KindPosition,
+		Source:       SourceOGN,
+		Type:         "towplane",
+		Name:         "APL",
+		Address:      "123456",
+		AddressType:  "flarm id",
+		Registration: "4X-APL",
+		Lat:          32.5,
+		Long:         35.1,
+		Alt:          10,
+		Climb:        -3.1,
+		Dir:          123,
+		TurnRate:     -1.2,
 	}
 	assert.True(t, ok)
 	got.Time = time.Time{} // Clear time before comparing.
 	assert.Equal(t, want, got)
+	// Third line of data is dropped due to low SNR.
+	got, ok = ogn.next()
+	assert.True(t, ok)
+	assert.Nil(t, got)
+
+	// Fourth line of data, with flags.
+	got, ok = ogn.next()
+	assert.True(t, ok)
+	baroAlt := 225.0
+	wantReception := &Reception{
+		PPSOffset:          0.885,
+		Frequency:          916.199,
+		FrequencyOffset:    -1.2,
+		HorizontalAccuracy: 5,
+		VerticalAccuracy:   6,
+		SNR:                26.8,
+		Signal:             37.5,
+		Errors:             2,
+		Distance:           2000,
+		Bearing:            99.2,
+		Elevation:          4.2,
+		Multichannel:       true,
+		BaroAlt:            &baroAlt,
+	}
+	assert.Equal(t, wantReception, got.Reception)
+
+	assert.Equal(t, LineCounts{Valid: 4, Unsupported: 3}, stats.Counts())
+}
+
+func TestOGNBadLines(t *testing.T) {
+	t.Parallel()
+
+	lines := "0.802sec:916.200MHz: 2:2:123456 garbage\n" +
+		"@@@ 0 user(s) and 0 logger(s) connected (plus you)\n" +
+		"0.802sec:916.200MHz: 2:2:123456 104436: [+32.5, +35.1]deg 10m -3.1m/s 0.4m/s 123.1deg -1.2deg/s\n"
+	ogn := newOGN(io.NopCloser(strings.NewReader(lines)), StationInfo{}, OGNConfig{})
+	stats := &LineStats{Name: SourceOGN, LogInterval: time.Minute}
+	ogn.CountTo(stats)
+
+	var got []*Data
+	for {
+		d, ok := ogn.next()
+		if !ok {
+			break
+		}
+		if d != nil {
+			got = append(got, d)
+		}
+	}
+	require.Len(t, got, 1)
+	assert.Equal(t, "123456", got[0].Address)
+	assert.Equal(t, LineCounts{Valid: 1, Malformed: 1, Unsupported: 1}, stats.Counts())
+}
+
+func TestTimeOfDay(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2021, 4, 22, 23, 59, 0, 0, time.UTC)
+
+	tests := []struct {
+		hhmmss string
+		want   time.Time
+	}{
+		{hhmmss: "235830", want: time.Date(2021, 4, 22, 23, 58, 30, 0, time.UTC)},
+		{hhmmss: "000010", want: time.Date(2021, 4, 23, 0, 0, 10, 0, time.UTC)},
+		{hhmmss: "invalid", want: time.Time{}},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, timeOfDay(tt.hhmmss, now))
+	}
+	assert.Equal(t, time.Date(2021, 4, 22, 23, 59, 50, 0, time.UTC), timeOfDay("235950", now.Add(2*time.Minute)))
+}
+
+func TestOGNConfigAccept(t *testing.T) {
+	t.Parallel()
+
+	r := &Reception{SNR: 20, Errors: 3, FrequencyOffset: -5.2}
+
+	tests := []struct {
+		cfg  OGNConfig
+		want bool
+	}{
+		{cfg: OGNConfig{}, want: true},
+		{cfg: OGNConfig{MinSNR: 20, MaxErrors: 3, MaxFrequencyOffset: 5.2}, want: true},
+		{cfg: OGNConfig{MinSNR: 21}, want: false},
+		{cfg: OGNConfig{MaxErrors: 2}, want: false},
+		{cfg: OGNConfig{MaxFrequencyOffset: 5}, want: false},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, tt.cfg.accept(r), "%+v", tt.cfg)
+	}
 }
@@ -137,6 +137,12 @@ func init() {
 	})
 }
 
+// NonDirectional returns whether the target has unknown bearing (transponder Mode-C/S), in which
+// case RelativeNorth is the estimated distance to the target.
+func (e TypePFLAA) NonDirectional() bool {
+	return len(e.Fields) > 2 && e.Fields[2] == ""
+}
+
 // 1 = official ICAO 24-bit aircraft address
 // 2 = stable FLARM ID (chosen by FLARM)
 // 3 = anonymous ID, used if stealth mode is activated
@@ -201,3 +207,14 @@ func aircraftType(v string) string {
 	}
 	return "unknown"
 }
+
+// aircraftTypeCode returns the hexadecimal aircraft type code of an aircraft type returned by
+// aircraftType.
+func aircraftTypeCode(tp string) string {
+	for _, code := range "123456789BCDF" {
+		if aircraftType(string(code)) == tp {
+			return string(code)
+		}
+	}
+	return "0"
+}
@@ -0,0 +1,175 @@
+package flarmport
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/adrianmo/go-nmea"
+)
+
+// pflacTimeout is the time to wait for a response of a configuration request.
+const pflacTimeout = 2 * time.Second
+
+// Configuration keys, from the FLARM configuration specification.
+const (
+	// ConfigID is the radio ID: "0xFFFFFF" for the ICAO address of the device, or a hexadecimal ID.
+	ConfigID = "ID"
+	// ConfigRange is the maximal range of received aircraft, in meters.
+	ConfigRange = "RANGE"
+	// ConfigAircraftType is the aircraft type code, as in the PFLAA aircraft type.
+	ConfigAircraftType = "ACFT"
+	// ConfigNMEAOut is the NMEA output configuration: 0 no output, 1 all sentences, 2 GPS only, 3
+	// FLARM only, etc.
+	ConfigNMEAOut = "NMEAOUT"
+	// ConfigBaud is the serial baud rate code: 0 4800, 1 9600, 2 19200, 4 38400, 5 57600. The port
+	// needs to be reopened with the new baud rate after it is changed.
+	ConfigBaud = "BAUD"
+)
+
+// ConfigKeys are the configuration keys that are read by DeviceConfig.
+var ConfigKeys = []string{ConfigID, ConfigRange, ConfigAircraftType, ConfigNMEAOut, ConfigBaud}
+
+// From spec:
+// PFLAC: Configuration query, set and response.
+// Syntax: PFLAC,<QueryType>,<Key>,<Value>
+// QueryType is R for request, S for set, and A for the answer of the device. An invalid request
+// is answered with PFLAC,A,ERROR.
+type TypePFLAC struct {
+	nmea.BaseSentence `json:"-"`
+	QueryType         string
+	Key               string
+	// Value of the key. Multiple values are separated by commas.
+	Value string
+}
+
+func init() {
+	nmea.MustRegisterParser("FLAC", func(s nmea.BaseSentence) (nmea.Sentence, error) {
+		p := nmea.NewParser(s)
+		ret := TypePFLAC{
+			BaseSentence: s,
+			QueryType:    p.String(0, "query type"),
+			Key:          p.String(1, "key"),
+		}
+		if len(s.Fields) > 2 {
+			ret.Value = strings.Join(s.Fields[2:], ",")
+		}
+		return ret, p.Err()
+	})
+}
+
+// DeviceConfig is the configuration of a FLARM device.
+type DeviceConfig struct {
+	ID           string
+	Range        string
+	AircraftType string
+	NMEAOut      string
+	Baud         string
+}
+
+// DeviceConfig reads the device configuration.
+func (p *Port) DeviceConfig(ctx context.Context) (DeviceConfig, error) {
+	var c DeviceConfig
+	for _, f := range []struct {
+		key   string
+		value *string
+	}{
+		{ConfigID, &c.ID},
+		{ConfigRange, &c.Range},
+		{ConfigAircraftType, &c.AircraftType},
+		{ConfigNMEAOut, &c.NMEAOut},
+		{ConfigBaud, &c.Baud},
+	} {
+		v, err := p.Config(ctx, f.key)
+		if err != nil {
+			return c, err
+		}
+		*f.value = v
+	}
+	return c, nil
+}
+
+// Config queries a configuration value of the device. It requires that the port is being ranged,
+// such that the response is read.
+func (p *Port) Config(ctx context.Context, key string) (string, error) {
+	return p.request(ctx, "R", key)
+}
+
+// SetConfig sets a configuration value of the device, and returns the value that was applied by
+// the device. It requires that the port is being ranged, such that the response is read.
+func (p *Port) SetConfig(ctx context.Context, key, value string) (string, error) {
+	if strings.ContainsAny(value, "$*\r\n") {
+		return "", fmt.Errorf("invalid value %q", value)
+	}
+	return p.request(ctx, "S", key, value)
+}
+
+// request sends a configuration request and waits for its response.
+func (p *Port) request(ctx context.Context, queryType string, fields ...string) (string, error) {
+	if p.writer == nil {
+		return "", fmt.Errorf("port is read-only")
+	}
+	key := fields[0]
+	if key == "" || strings.ContainsAny(key, "$*,\r\n") {
+		return "", fmt.Errorf("invalid key %q", key)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, pflacTimeout)
+	defer cancel()
+
+	// Only one request is in flight, such that responses can be matched.
+	if err := p.lockConfig(ctx); err != nil {
+		return "", err
+	}
+	defer p.unlockConfig()
+
+	// Drop unmatched responses of previous requests.
+	for len(p.responses) > 0 {
+		<-p.responses
+	}
+
+	args := []interface{}{queryType}
+	for _, f := range fields {
+		args = append(args, f)
+	}
+	_, err := io.WriteString(p.writer, nmeaSentence("PFLAC", args...)+"\r\n")
+	if err != nil {
+		return "", fmt.Errorf("failed writing request: %v", err)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return "", fmt.Errorf("no response for %s: %v", key, ctx.Err())
+		case r := <-p.responses:
+			switch {
+			case r.Key == "ERROR":
+				return "", fmt.Errorf("device rejected %s request", key)
+			case r.Key == key:
+				return r.Value, nil
+			}
+		}
+	}
+}
+
+// lockConfig waits until no other configuration request is in flight. Configuration requests are
+// short, while a binary session might take minutes, so it fails immediately if a binary session
+// is in progress. It must be followed by unlockConfig if it succeeds.
+func (p *Port) lockConfig(ctx context.Context) error {
+	select {
+	case p.config <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	if p.binary {
+		p.unlockConfig()
+		return errBinarySession
+	}
+	return nil
+}
+
+func (p *Port) unlockConfig() {
+	<-p.config
+}
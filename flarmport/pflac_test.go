@@ -0,0 +1,112 @@
+package flarmport
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/adrianmo/go-nmea"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPFLAC(t *testing.T) {
+	t.Parallel()
+
+	v, err := nmea.Parse("$PFLAC,A,ID,0xFFFFFF*70")
+	require.NoError(t, err)
+	assert.Equal(t, TypePFLAC{BaseSentence: v.(TypePFLAC).BaseSentence, QueryType: "A", Key: "ID", Value: "0xFFFFFF"}, v)
+
+	v, err = nmea.Parse(nmeaSentence("PFLAC", "A", "PRIV", 1, 2))
+	require.NoError(t, err)
+	assert.Equal(t, "1,2", v.(TypePFLAC).Value)
+}
+
+func TestPortConfig(t *testing.T) {
+	t.Parallel()
+
+	conn, device := net.Pipe()
+	defer device.Close()
+	p := newPort(conn, StationInfo{})
+	defer p.Close()
+	go p.RangeHandler(context.Background(), Handler{})
+
+	config := map[string]string{
+		ConfigID:           "0xFFFFFF",
+		ConfigRange:        "65535",
+		ConfigAircraftType: "1",
+		ConfigNMEAOut:      "1",
+		ConfigBaud:         "2",
+	}
+	// Simulate a device that answers configuration requests, and ignores unknown keys.
+	go func() {
+		r := bufio.NewReader(device)
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			v, err := nmea.Parse(line)
+			if err != nil {
+				continue
+			}
+			fields := v.(TypePFLAC).BaseSentence.Fields
+			var resp string
+			switch {
+			case fields[1] == "UNKNOWN":
+				continue
+			case fields[1] == "BAD":
+				resp = nmeaSentence("PFLAC", "A", "ERROR")
+			case fields[0] == "S":
+				config[fields[1]] = fields[2]
+				fallthrough
+			default:
+				resp = nmeaSentence("PFLAC", "A", fields[1], config[fields[1]])
+			}
+			// Traffic is interleaved with the responses.
+			io.WriteString(device, "$PFLAU,3,1,2,1,0,,0,,,*4F\r\n"+resp+"\r\n")
+		}
+	}()
+
+	ctx := context.Background()
+	got, err := p.DeviceConfig(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, DeviceConfig{ID: "0xFFFFFF", Range: "65535", AircraftType: "1", NMEAOut: "1", Baud: "2"}, got)
+
+	value, err := p.SetConfig(ctx, ConfigRange, "3000")
+	require.NoError(t, err)
+	assert.Equal(t, "3000", value)
+	value, err = p.Config(ctx, ConfigRange)
+	require.NoError(t, err)
+	assert.Equal(t, "3000", value)
+
+	_, err = p.Config(ctx, "BAD")
+	assert.Error(t, err)
+
+	// No response.
+	_, err = p.Config(ctx, "UNKNOWN")
+	assert.Error(t, err)
+
+	_, err = p.SetConfig(ctx, ConfigID, "1*2")
+	assert.Error(t, err)
+
+	// Requests through the device.
+	var d Device
+	_, err = d.DeviceConfig(ctx)
+	assert.Error(t, err)
+	d.Set(p)
+	value, err = d.SetConfig(ctx, ConfigAircraftType, "3")
+	require.NoError(t, err)
+	assert.Equal(t, "3", value)
+}
+
+func TestPortConfigReadOnly(t *testing.T) {
+	t.Parallel()
+
+	p := newPort(io.NopCloser(strings.NewReader("")), StationInfo{})
+	_, err := p.Config(context.Background(), ConfigID)
+	assert.Error(t, err)
+}
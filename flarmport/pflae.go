@@ -0,0 +1,145 @@
+package flarmport
+
+import (
+	"strings"
+
+	"github.com/adrianmo/go-nmea"
+)
+
+// From spec:
+// PFLAE: Self-test result and errors codes
+// Syntax: PFLAE,<QueryType>,<Severity>,<ErrorCode>,<Message>
+type TypePFLAE struct {
+	nmea.BaseSentence `json:"-"`
+	// QueryType is R for request, and A for the answer of the device or a spontaneous report.
+	QueryType string
+	// Severity of the error:
+	//  0 = no error
+	//  1 = information message only
+	//  2 = functionality may be reduced
+	//  3 = fatal problem, device will not work
+	Severity string
+	// Hexadecimal error code, see errorCode.
+	ErrorCode string
+	// Optional error message.
+	Message string
+}
+
+func init() {
+	nmea.MustRegisterParser("FLAE", func(s nmea.BaseSentence) (nmea.Sentence, error) {
+		p := nmea.NewParser(s)
+		ret := TypePFLAE{
+			BaseSentence: s,
+			QueryType:    p.String(0, "query type"),
+		}
+		// Fields are empty in requests, and the message is optional.
+		err := p.Err()
+		ret.Severity = severity(p.String(1, "severity"))
+		ret.ErrorCode = strings.ToUpper(p.String(2, "error code"))
+		ret.Message = p.String(3, "message")
+		return ret, err
+	})
+}
+
+func severity(v string) string {
+	switch v {
+	case "0":
+		return "no error"
+	case "1":
+		return "information"
+	case "2":
+		return "reduced functionality"
+	case "3":
+		return "fatal"
+	}
+	return "unknown"
+}
+
+// errorCode returns the description of a hexadecimal error code.
+func errorCode(v string) string {
+	switch v {
+	case "", "0":
+		return ""
+	case "11":
+		return "firmware expired"
+	case "12":
+		return "firmware update error"
+	case "21":
+		return "power (e.g. voltage < 8V)"
+	case "22":
+		return "UI error"
+	case "23":
+		return "audio error"
+	case "24":
+		return "ADC error"
+	case "25":
+		return "SD card error"
+	case "26":
+		return "USB error"
+	case "27":
+		return "LED error"
+	case "28":
+		return "EEPROM error"
+	case "29":
+		return "general hardware error"
+	case "2A":
+		return "transponder receiver Mode-C/S/ADS-B unserviceable"
+	case "2B":
+		return "EEPROM error"
+	case "2C":
+		return "GPIO error"
+	case "31":
+		return "GPS communication"
+	case "32":
+		return "configuration of GPS module"
+	case "33":
+		return "GPS antenna"
+	case "41":
+		return "RF communication"
+	case "42":
+		return "another FLARM device with the same radio ID"
+	case "43":
+		return "wrong ICAO 24-bit address or radio ID"
+	case "51":
+		return "communication"
+	case "61":
+		return "flash memory"
+	case "71":
+		return "pressure sensor"
+	case "81":
+		return "obstacle database"
+	case "82":
+		return "obstacle database expired"
+	case "91":
+		return "flight recorder"
+	case "93":
+		return "engine-noise recording not possible"
+	case "94":
+		return "range analyzer"
+	case "A1":
+		return "configuration error"
+	case "B1":
+		return "invalid obstacle database license"
+	case "B2":
+		return "invalid IGC feature license"
+	case "B3":
+		return "invalid AUD feature license"
+	case "B4":
+		return "invalid ENL feature license"
+	case "B5":
+		return "invalid RFB feature license"
+	case "B6":
+		return "invalid TIS feature license"
+	case "100":
+		return "generic error"
+	case "101":
+		return "flash file system error"
+	case "110":
+		return "failure updating firmware of external display"
+	case "120":
+		return "device is operated outside the designated region"
+	case "F1":
+		return "other"
+	}
+	return "unknown"
+}
@@ -0,0 +1,34 @@
+package flarmport
+
+import "github.com/adrianmo/go-nmea"
+
+// From spec:
+// PFLAV: Version information
+// Syntax: PFLAV,<QueryType>,<HwVersion>,<SwVersion>,<ObstVersion>
+type TypePFLAV struct {
+	nmea.BaseSentence `json:"-"`
+	// QueryType is R for request, and A for the answer of the device.
+	QueryType string
+	// Hardware version, e.g. "2.00".
+	HwVersion string
+	// Firmware version, e.g. "6.00".
+	SwVersion string
+	// Obstacle database version. Empty if no obstacle database is installed.
+	ObstVersion string
+}
+
+func init() {
+	nmea.MustRegisterParser("FLAV", func(s nmea.BaseSentence) (nmea.Sentence, error) {
+		p := nmea.NewParser(s)
+		ret := TypePFLAV{
+			BaseSentence: s,
+			QueryType:    p.String(0, "query type"),
+		}
+		// Fields are empty in requests.
+		err := p.Err()
+		ret.HwVersion = p.String(1, "hw version")
+		ret.SwVersion = p.String(2, "sw version")
+		ret.ObstVersion = p.String(3, "obst version")
+		return ret, err
+	})
+}
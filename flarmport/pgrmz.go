@@ -12,7 +12,7 @@ import "github.com/adrianmo/go-nmea"
 type TypePGRMZ struct {
 	nmea.BaseSentence `json:"-"`
 
-	// Gives the barometric altitude in feet (1 ft = 0.3028 m) and can be negative.
+	// Gives the barometric altitude in feet (1 ft = 0.3048 m) and can be negative.
 	Altitude int64
 }
 
@@ -5,20 +5,15 @@
 //
 // A usage example:
 //
-// 	flarm, err := flarmport.Open("/dev/ttyS0")
+// 	flarm, err := flarmport.Open("/dev/ttyS0", 19200, flarmport.StationInfo{})
 // 	if err != nil {
 // 		log.Fatal(err)
 // 	}
 // 	defer flarm.Close()
-// 	for flarm.Next() {
-// 		if err := flarm.Err(); err != nil {
-// 			log.Printf("Unknown format: %v", err)
-// 		}
-// 		entry := flarm.Value()
-// 		if entry != nil {
-// 			fmt.Printf("%+v", entry)
-// 		}
-// 	}
+// 	err = flarm.RangeHandler(ctx, flarmport.Handler{
+// 		Data:   func(d flarmport.Data) { fmt.Printf("aircraft: %+v\n", d) },
+// 		Status: func(s flarmport.Status) { fmt.Printf("status: %+v\n", s) },
+// 	})
 package flarmport
 
 import (
@@ -29,6 +24,8 @@ import (
 	"io"
 	"log"
 	"math"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/adrianmo/go-nmea"
@@ -37,34 +34,118 @@ import (
 
 var defaultTimezone = time.UTC
 
+// TimePolicy defines the source of the time of the received data.
+type TimePolicy string
+
+const (
+	// TimeGPS uses the GPS time, and falls back to the wall clock when the GPS time is missing.
+	// This is the default policy.
+	TimeGPS TimePolicy = "gps"
+	// TimeGPSOnly uses the GPS time, and drops data when the GPS time is missing.
+	TimeGPSOnly TimePolicy = "gps-only"
+	// TimeWall always uses the wall clock.
+	TimeWall TimePolicy = "wall"
+)
+
+// Valid returns whether the policy is a known policy. An empty policy is valid.
+func (tp TimePolicy) Valid() bool {
+	switch tp {
+	case "", TimeGPS, TimeGPSOnly, TimeWall:
+		return true
+	}
+	return false
+}
+
 // StationInfo is information about the station where the flarm is location.
 type StationInfo struct {
-	// Latitude and longitude coordinates of the station.
+	// Latitude and longitude coordinates of the station. Used when the flarm has no GPS fix.
 	Lat, Long float64
-	// Altitude of the station, in meters.
+	// Altitude of the station, in meters. Used when the flarm has no GPS fix.
 	Alt float64
 	// Time zone of the station. Default is set to UTC.
 	TimeZone *time.Location
-	// Mapping of flarm ID to plane sign.
-	IDMap map[string]string
+	// TimePolicy defines the source of the time of the received data. Default is TimeGPS.
+	TimePolicy TimePolicy
+	// QNH in hPa, used to calculate the altitude shown by altimeters. Default is the standard
+	// pressure.
+	QNH float64
+	// Mapping of flarm ID to known aircraft.
+	IDMap map[string]Aircraft
 }
 
+// MapID returns the name to display for a given flarm ID.
 func (si StationInfo) MapID(id string) string {
-	if mapped := si.IDMap[id]; mapped != "" {
-		return mapped
+	return si.IDMap[id].displayName(id)
+}
+
+// identify sets the identification fields of the data according to the given address.
+func (si StationInfo) identify(d *Data, address, addressType string) {
+	a := si.IDMap[address]
+	d.Name = a.displayName(address)
+	d.Address = address
+	d.AddressType = addressType
+	d.Registration = a.Registration
+	d.CompetitionID = a.CompetitionID
+}
+
+// timestamp returns the time of received data, according to the time policy, given the GPS time
+// or zero time if it is missing. It returns false if the data should be dropped.
+func (si StationInfo) timestamp(gps time.Time) (time.Time, bool) {
+	switch si.TimePolicy {
+	case TimeWall:
+		return time.Now().In(si.TimeZone), true
+	case TimeGPSOnly:
+		if gps.IsZero() {
+			return time.Time{}, false
+		}
+		return gps.In(si.TimeZone), true
+	default:
+		if gps.IsZero() {
+			return time.Now().In(si.TimeZone), true
+		}
+		return gps.In(si.TimeZone), true
 	}
-	return id
 }
 
 // Port is a connection to a FLARM serial port.
 type Port struct {
 	scanner *bufio.Scanner
+	// reader reads the connection, and allows diverting it to a binary session.
+	reader *divertReader
 	io.Closer
 	station StationInfo
+	// fix is the GPS fix of the receiver, used as the reference position of received aircraft.
+	fix fix
+	// baro is the barometric altitude of the receiver.
+	baro baro
+	// recorders record the raw lines.
+	recorders []LineRecorder
+	// stats counts the read lines. It may be nil.
+	stats *LineStats
+	// writer is used to send configuration requests. It is nil if the connection is read-only.
+	writer io.Writer
+	// config is a semaphore that allows a single configuration request at a time, and binary is
+	// set while a binary session is in progress. binary is guarded by the semaphore.
+	config chan struct{}
+	binary bool
+	// responses are the received configuration responses.
+	responses chan TypePFLAC
+	// deviceStatus is the last reported device status, guarded by statusMu.
+	deviceStatus DeviceStatus
+	statusMu     sync.Mutex
 }
 
 // Open opens a serial connection to a given FLARM port.
 func Open(port string, baudRate uint, station StationInfo) (*Port, error) {
+	serial, err := openSerial(port, baudRate)
+	if err != nil {
+		return nil, err
+	}
+	return newPort(serial, station), nil
+}
+
+// openSerial opens a serial port with the FLARM serial settings.
+func openSerial(port string, baudRate uint) (io.ReadWriteCloser, error) {
 	serial, err := serial.Open(serial.OpenOptions{
 		PortName: port,
 		// Baud rate from spec: "The baud rate can be configured by commands described in FLARM
@@ -79,55 +160,128 @@ func Open(port string, baudRate uint, station StationInfo) (*Port, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed open serial port: %v", err)
 	}
+	return serial, nil
+}
+
+// newPort returns a port that reads NMEA sentences from the given connection.
+func newPort(conn io.ReadCloser, station StationInfo) *Port {
 	// Create a scanner that splits on CR.
-	s := bufio.NewScanner(serial)
+	r := &divertReader{r: conn}
+	s := bufio.NewScanner(r)
 	s.Split(splitCR)
 
 	if station.TimeZone == nil {
 		station.TimeZone = defaultTimezone
 	}
+	if station.QNH == 0 {
+		station.QNH = StandardQNH
+	}
 
-	return &Port{
-		scanner: s,
-		Closer:  serial,
-		station: station,
-	}, nil
+	p := &Port{
+		scanner:   s,
+		reader:    r,
+		Closer:    conn,
+		station:   station,
+		responses: make(chan TypePFLAC, 1),
+		config:    make(chan struct{}, 1),
+	}
+	if w, ok := conn.(io.Writer); ok {
+		p.writer = w
+	}
+	return p
 }
 
-// Range iterates and parses data from the serial connection. It exists when the port is closed.
+// Range iterates over the positions of other aircraft. See RangeHandler for reading all values.
 func (p *Port) Range(ctx context.Context, f func(Data)) error {
+	return p.RangeHandler(ctx, Handler{Data: f})
+}
+
+// RangeHandler iterates and parses data from the serial connection. It exists when the port is
+// closed.
+func (p *Port) RangeHandler(ctx context.Context, h Handler) error {
 	for ctx.Err() == nil {
 		value, ok := p.next()
 		if !ok {
 			return nil
 		}
-		if value != nil && ctx.Err() == nil {
-			f(*value)
+		if ctx.Err() == nil {
+			h.handle(value)
 		}
 	}
 	return ctx.Err()
 }
 
-// next used by Range and exist for testing purposes.
-func (p *Port) next() (*Data, bool) {
+// RecordTo records the raw lines that are read from the port.
+func (p *Port) RecordTo(r LineRecorder) {
+	p.recorders = append(p.recorders, r)
+}
+
+// CountTo counts the lines that are read from the port in the given stats.
+func (p *Port) CountTo(s *LineStats) {
+	p.stats = s
+}
+
+// next used by Range and exist for testing purposes. The returned value is either *Data or
+// *Status, or nil if the line did not produce any value. Bad lines are counted and skipped, it
+// returns false only when the connection ends.
+func (p *Port) next() (interface{}, bool) {
 	if !p.scanner.Scan() {
 		// Stop scanning.
 		return nil, false
 	}
 	line := p.scanner.Text()
+	for _, r := range p.recorders {
+		r.Record(line)
+	}
+	if strings.TrimSpace(line) == "" {
+		return nil, true
+	}
 	value, err := nmea.Parse(line)
 	if err != nil {
-		// Unknown NMEA, ignore...
+		// GPS sentences without a position fix might fail parsing, but still have a valid time.
+		if p.fix.updateRaw(line) {
+			p.stats.count(LineValid, line, nil)
+		} else {
+			p.stats.count(nmeaLineClass(line), line, err)
+		}
 		return nil, true
 	}
 
+	var v interface{}
 	switch e := value.(type) {
 	case TypePFLAA:
-		return p.station.processPFLAA(e), true
+		v = p.processPFLAA(e)
+	case TypePFLAU:
+		v = p.processPFLAU(e)
+	case nmea.RMC:
+		p.fix.updateRMC(e)
+	case nmea.GGA:
+		p.fix.updateGGA(e)
+	case TypePGRMZ:
+		p.baro.updatePGRMZ(e)
+	case TypePFLAV:
+		p.updatePFLAV(e)
+	case TypePFLAE:
+		p.updatePFLAE(e)
+	case TypePFLAC:
+		if e.QueryType == "A" {
+			select {
+			case p.responses <- e:
+			default:
+			}
+		}
+	default:
+		p.stats.count(LineUnsupported, line, nil)
+		return nil, true
 	}
-	return nil, true
+	p.stats.count(LineValid, line, nil)
+	return v, true
 }
 
+// maxLineLen is the maximal length of a line. Longer lines are split, such that noise on the
+// connection doesn't fail the scanner.
+const maxLineLen = 4096
+
 func splitCR(data []byte, atEOF bool) (advance int, token []byte, err error) {
 	if atEOF && len(data) == 0 {
 		return 0, nil, nil
@@ -137,20 +291,64 @@ func splitCR(data []byte, atEOF bool) (advance int, token []byte, err error) {
 		return i + 1, data[0:i], nil
 	}
 	// If we're at EOF, we have a final, non-terminated line. Return it.
-	if atEOF {
+	if atEOF || len(data) >= maxLineLen {
 		return len(data), data, nil
 	}
 	// Request more data.
 	return 0, nil, nil
 }
 
+// Default source names of the readers.
+const (
+	SourceFlarm = "flarm"
+	SourceOGN   = "ogn"
+	SourceAPRS  = "aprs"
+	SourceGDL90 = "gdl90"
+	SourceADSB  = "adsb"
+)
+
+// Kinds of targets.
+const (
+	// KindPosition is a target with a known position.
+	KindPosition = "position"
+	// KindRangeOnly is a non-directional target (transponder Mode-C/S) with an unknown bearing.
+	// Its position is the position of the receiver, and its Distance is the estimated distance
+	// from the receiver.
+	KindRangeOnly = "range-only"
+)
+
 type Data struct {
-	Name      string
-	Lat, Long float64 `gorm:"type=float,precision=2"`
+	// Kind of the target: KindPosition or KindRangeOnly.
+	Kind string
+	// Name to display for the aircraft. It is mapped from the address, or the address itself if
+	// the aircraft is not known.
+	Name string
+	// Raw address of the aircraft, as 6-digit hexadecimal value.
+	Address string `gorm:"index"`
+	// Interpretation of the address: "official" (ICAO), "flarm id", "anonymous", "ogn" or
+	// "unknown".
+	AddressType string
+	// Registration and competition ID of the aircraft, if known.
+	Registration  string
+	CompetitionID string
+	Lat, Long     float64 `gorm:"type=float,precision=2"`
+	// Estimated horizontal distance in m from the receiver, for range-only targets.
+	Distance float64 `json:",omitempty"`
+	// Relative altitude in m above the receiver, for range-only targets.
+	RelativeAlt float64 `json:",omitempty"`
 	// Direction of airplane (In degrees relative to N)
 	Dir int
 	// Altitude in m
 	Alt float64
+	// Pressure altitude in m, relative to the standard pressure. Nil if the barometric altitude
+	// of the receiver is unknown.
+	PressureAlt *float64
+	// Flight level, the pressure altitude in hundreds of feet. Nil if the barometric altitude of
+	// the receiver is unknown.
+	FlightLevel *int
+	// Altitude in m shown by an altimeter that is set to the station QNH. Nil if the barometric
+	// altitude of the receiver is unknown.
+	QNHAlt *float64
 	// Ground speed in m/s
 	GroundSpeed int64
 	// Climb rate in m/s
@@ -160,29 +358,119 @@ type Data struct {
 	Type       string
 	Time       time.Time
 	AlarmLevel int
+	// Reception quality, for data received from OGN.
+	Reception *Reception `gorm:"-" json:",omitempty"`
+	// Source is the name of the source that reported the data, e.g. "flarm" or "ogn".
+	Source string
+	// SeenBy is comma separated names of all the sources that recently reported the aircraft.
+	// Only set when reading from a Fusion.
+	SeenBy string `json:",omitempty"`
 }
 
 func (o *Data) TableName() string { return "logs" }
 
-func (s StationInfo) processPFLAA(e TypePFLAA) *Data {
-	id := s.MapID(e.ID)
-	if id == "" {
+// Status is the operating status of the flarm device, and the priority intruder that it is
+// currently warning about.
+type Status struct {
+	// Number of devices with unique IDs currently received.
+	Rx int
+	// Transmission status: "OK" or "no transmission".
+	Tx string
+	// GPS status: "no signal", "valid on ground" or "valid airborne".
+	GPS string
+	// Power is false on under or over voltage.
+	Power bool
+	// Alarm level, from 0 (no alarm) to 3 (0-8 seconds to impact).
+	AlarmLevel int
+	// Type of alarm: "no alarm", "aircraft" or "obstacle / zone".
+	AlarmType string
+	// Intruder is the priority intruder. It is nil when no aircraft are within range and no
+	// alarms are generated.
+	Intruder *Intruder
+	Time     time.Time
+}
+
+// Intruder is the aircraft or obstacle that the flarm device reports as the most dangerous one.
+type Intruder struct {
+	// Name of the intruder, mapped from its ID. Empty when the ID is unknown.
+	Name string
+	// Relative bearing in degrees from own ground track to the intruder, clockwise. Zero for
+	// non-directional targets, obstacles and alert zones.
+	RelativeBearing int
+	// Relative vertical separation in meters above own position.
+	RelativeVertical int
+	// Relative horizontal distance in meters.
+	RelativeDistance int
+}
+
+func (p *Port) processPFLAA(e TypePFLAA) *Data {
+	// Non-directional Mode-C targets have no ID.
+	if e.ID == "" && !e.NonDirectional() {
 		log.Println("Ignoring empty ID entry.")
 		return nil
 	}
-	lat, long := add(s.Lat, s.Long, float64(e.RelativeNorth), float64(e.RelativeEast))
-	return &Data{
-		Name:        id,
+	t, ok := p.station.timestamp(p.fix.time())
+	if !ok {
+		return nil
+	}
+	// Positions are relative to the receiver.
+	ownLat, ownLong, ownAlt := p.fix.location(p.station)
+	lat, long := add(ownLat, ownLong, float64(e.RelativeNorth), float64(e.RelativeEast))
+	d := &Data{
+		Kind:        KindPosition,
+		Source:      SourceFlarm,
 		Lat:         lat,
 		Long:        long,
 		Dir:         int(e.Track),
-		Alt:         s.Alt + float64(e.RelativeVertical),
+		Alt:         ownAlt + float64(e.RelativeVertical),
 		Climb:       e.ClimbRate,
 		GroundSpeed: e.GroundSpeed,
 		Type:        e.AircraftType,
 		AlarmLevel:  int(e.AlarmLevel),
-		Time:        time.Now().In(s.TimeZone),
+		Time:        t,
+	}
+	p.station.identify(d, e.ID, e.IDType)
+	if e.NonDirectional() {
+		d.Kind = KindRangeOnly
+		d.Lat, d.Long = ownLat, ownLong
+		d.Distance = float64(e.RelativeNorth)
+		d.RelativeAlt = float64(e.RelativeVertical)
+		if d.Name == "" {
+			d.Name = "Mode-C"
+		}
+	}
+	if ownPressureAlt, ok := p.baro.pressureAlt(); ok {
+		p.station.setPressureAlt(d, ownPressureAlt+float64(e.RelativeVertical))
+	}
+	return d
+}
+
+func (p *Port) processPFLAU(e TypePFLAU) *Status {
+	t, ok := p.station.timestamp(p.fix.time())
+	if !ok {
+		return nil
+	}
+	status := &Status{
+		Rx:         int(e.Rx),
+		Tx:         e.Tx,
+		GPS:        e.GPS,
+		Power:      e.Power == 1,
+		AlarmLevel: int(e.AlarmLevel),
+		AlarmType:  e.AlarmType,
+		Time:       t,
+	}
+	// The relative distance field is empty when there is no intruder.
+	if len(e.Fields) > 8 && e.Fields[8] != "" {
+		status.Intruder = &Intruder{
+			RelativeBearing:  int(e.RelativeBearing),
+			RelativeVertical: int(e.RelativeVertical),
+			RelativeDistance: int(e.RelativeDistance),
+		}
+		if e.ID != "" {
+			status.Intruder.Name = p.station.MapID(e.ID)
+		}
 	}
+	return status
 }
 
 func add(lat, lon float64, relN, relE float64) (float64, float64) {
@@ -6,11 +6,13 @@ import (
 	"io"
 	"os/exec"
 	"regexp"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/jacobsa/go-serial/serial"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 var reSocatPTY = regexp.MustCompile("N PTY is (.+)$")
@@ -56,17 +58,21 @@ func TestOpen(t *testing.T) {
 	tests := []struct {
 		name string
 		in   string
-		want *Data
+		want interface{}
 	}{
 		{
 			name: "PFLAA",
 			in:   "$PFLAA,0,-1388,-330,465,2,DD8E8B,78,,44,2.8,2*6F",
 			want: &Data{
+				Kind:        KindPosition,
+				Source:      SourceFlarm,
 				AlarmLevel:  0,
 				Lat:         -0.01246861614357896,
 				Long:        -0.002964440437594421,
 				Alt:         465,
 				Name:        "DD8E8B",
+				Address:     "DD8E8B",
+				AddressType: "flarm id",
 				GroundSpeed: 44,
 				Climb:       2.8,
 				Dir:         78,
@@ -76,7 +82,13 @@ func TestOpen(t *testing.T) {
 		{
 			name: "PFLAU",
 			in:   "$PFLAU,2,1,1,1,0,,0,,*61",
-			want: nil,
+			want: &Status{
+				Rx:        2,
+				Tx:        "no transmission",
+				GPS:       "valid on ground",
+				Power:     true,
+				AlarmType: "no alarm",
+			},
 		},
 		{
 			name: "PGRMZ",
@@ -110,11 +122,332 @@ func parsePorts(stderr io.Reader) (p1, p2 string) {
 	return ports[0], ports[1]
 }
 
-func clean(t *testing.T, got *Data) *Data {
-	if got == nil {
-		return got
+func TestPortStatus(t *testing.T) {
+	t.Parallel()
+
+	in := "$PFLAU,3,1,2,1,2,-30,2,-14,97,DD8E8B*40\r"
+	p := newPort(io.NopCloser(strings.NewReader(in)), StationInfo{IDMap: map[string]Aircraft{"DD8E8B": {Name: "APL"}}})
+
+	got, ok := p.next()
+	require.True(t, ok)
+	want := &Status{
+		Rx:         3,
+		Tx:         "no transmission",
+		GPS:        "valid airborne",
+		Power:      true,
+		AlarmLevel: 2,
+		AlarmType:  "aircraft",
+		Intruder: &Intruder{
+			Name:             "APL",
+			RelativeBearing:  -30,
+			RelativeVertical: -14,
+			RelativeDistance: 97,
+		},
+	}
+	assert.Equal(t, want, clean(t, got))
+
+	_, ok = p.next()
+	assert.False(t, ok)
+}
+
+func TestPortLineStats(t *testing.T) {
+	t.Parallel()
+
+	in := "$PFLAU,3,1,2,1,0,,0,,,*00\r\n" + // Bad checksum.
+		"$PFLAJ,A,1,0*3D\r\n" + // Unknown sentence.
+		"$PFLAU,x,1,2,1,0,,0,,,*04\r\n" + // Invalid field.
+		"PFLAU,3,1\r\n" + // Partial sentence.
+		"$GPGSV,1,1,00*79\r\n" + // Not used.
+		"$GPRMC,123536,V,,,,,,,171220,,,N*54\r\n" + // No position fix.
+		"$GPRMC,123537,V,,N,,E,,,171220,,,N*5E\r\n" + // No position fix, fails parsing.
+		strings.Repeat("x", 5000) + "\r\n" + // Noise, split to two lines.
+		"$PFLAU,3,1,2,1,0,,0,,,*4F\r\n"
+	p := newPort(io.NopCloser(strings.NewReader(in)), StationInfo{})
+	stats := &LineStats{Name: SourceFlarm, LogInterval: time.Minute}
+	p.CountTo(stats)
+
+	var got []interface{}
+	for {
+		v, ok := p.next()
+		if !ok {
+			break
+		}
+		if s, ok := v.(*Status); ok && s != nil {
+			got = append(got, v)
+		}
+	}
+	assert.Len(t, got, 1)
+	want := LineCounts{Valid: 3, BadChecksum: 1, Unknown: 1, Malformed: 4, Unsupported: 1}
+	assert.Equal(t, want, stats.Counts())
+}
+
+func clean(t *testing.T, got interface{}) interface{} {
+	switch got := got.(type) {
+	case *Data:
+		if got != nil {
+			assert.False(t, got.Time.IsZero())
+			got.Time = time.Time{}
+		}
+	case *Status:
+		if got != nil {
+			assert.False(t, got.Time.IsZero())
+			got.Time = time.Time{}
+		}
 	}
-	assert.NotNil(t, got.Time)
-	got.Time = time.Time{}
 	return got
 }
+
+func TestPortFix(t *testing.T) {
+	t.Parallel()
+
+	const pflaa = "$PFLAA,0,0,0,10,2,DD8E8B,78,,44,2.8,2*6B"
+	in := strings.Join([]string{
+		pflaa,
+		"$GPRMC,123536.00,A,3235.79217,N,03514.10416,E,0.006,,171220,,,A*7D",
+		pflaa,
+		"$GPGGA,123536.00,3235.79217,N,03514.10416,E,1,06,1.22,52.3,M,18.0,M,,*6A",
+		pflaa,
+	}, "\r")
+	p := newPort(io.NopCloser(strings.NewReader(in)), StationInfo{Lat: 32, Long: 35, Alt: 100})
+
+	const (
+		fixLat  = 32 + 35.79217/60
+		fixLong = 35 + 14.10416/60
+	)
+
+	tests := []struct {
+		lat, long, alt float64
+	}{
+		{lat: 32, long: 35, alt: 110},           // No fix, use station location.
+		{lat: fixLat, long: fixLong, alt: 110},  // Fix without altitude.
+		{lat: fixLat, long: fixLong, alt: 62.3}, // Fix with altitude.
+	}
+
+	for _, tt := range tests {
+		// Skip GPS sentences.
+		var v interface{}
+		for v == nil {
+			var ok bool
+			v, ok = p.next()
+			require.True(t, ok)
+		}
+		got := v.(*Data)
+		assert.InDelta(t, tt.lat, got.Lat, 1e-9)
+		assert.InDelta(t, tt.long, got.Long, 1e-9)
+		assert.InDelta(t, tt.alt, got.Alt, 1e-9)
+	}
+
+	// Stale fix falls back to station location.
+	p.fix.updated = time.Now().Add(-2 * fixTimeout)
+	lat, long, alt := p.fix.location(p.station)
+	assert.Equal(t, []float64{32, 35, 100}, []float64{lat, long, alt})
+}
+
+func TestPortBaro(t *testing.T) {
+	t.Parallel()
+
+	const pflaa = "$PFLAA,0,0,0,10,2,DD8E8B,78,,44,2.8,2*6B"
+	in := strings.Join([]string{pflaa, "$PGRMZ,78,F,2*05", pflaa}, "\r")
+	p := newPort(io.NopCloser(strings.NewReader(in)), StationInfo{QNH: 1023.25})
+
+	// No barometric altitude yet.
+	v, ok := p.next()
+	require.True(t, ok)
+	got := v.(*Data)
+	assert.Nil(t, got.PressureAlt)
+	assert.Nil(t, got.FlightLevel)
+	assert.Nil(t, got.QNHAlt)
+
+	v, ok = p.next()
+	require.True(t, ok)
+	assert.Nil(t, v)
+
+	v, ok = p.next()
+	require.True(t, ok)
+	got = v.(*Data)
+	require.NotNil(t, got.PressureAlt)
+	assert.InDelta(t, 33.7744, *got.PressureAlt, 1e-3)
+	assert.Equal(t, 1, *got.FlightLevel)
+	assert.InDelta(t, 116.468, *got.QNHAlt, 1e-2)
+}
+
+func TestQNHAlt(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		pressureAlt, qnh float64
+		want             float64
+	}{
+		{pressureAlt: 1000, qnh: StandardQNH, want: 1000},
+		{pressureAlt: 0, qnh: 1023.25, want: 82.757},
+		{pressureAlt: 1000, qnh: 1023.25, want: 1080.890},
+		{pressureAlt: 1000, qnh: 1003.25, want: 918.154},
+	}
+
+	for _, tt := range tests {
+		assert.InDelta(t, tt.want, qnhAlt(tt.pressureAlt, tt.qnh), 1e-2)
+	}
+	assert.Equal(t, 33, flightLevel(1000))
+}
+
+func TestPortTime(t *testing.T) {
+	t.Parallel()
+
+	const pflaa = "$PFLAA,0,0,0,10,2,DD8E8B,78,,44,2.8,2*6B"
+	in := strings.Join([]string{
+		pflaa,
+		"$GPRMC,123536.00,A,3235.79217,N,03514.10416,E,0.006,,171220,,,A*7D",
+		pflaa,
+	}, "\r")
+	gpsTime := time.Date(2020, 12, 17, 12, 35, 36, 0, time.UTC)
+
+	tests := []struct {
+		policy TimePolicy
+		// Whether the first value, before the GPS time is known, is dropped.
+		wantDrop bool
+		// Whether the second value, after the GPS time is known, uses the GPS time.
+		wantGPS bool
+	}{
+		{policy: "", wantGPS: true},
+		{policy: TimeGPS, wantGPS: true},
+		{policy: TimeGPSOnly, wantDrop: true, wantGPS: true},
+		{policy: TimeWall},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.policy), func(t *testing.T) {
+			p := newPort(io.NopCloser(strings.NewReader(in)), StationInfo{TimePolicy: tt.policy})
+
+			v, ok := p.next()
+			require.True(t, ok)
+			if tt.wantDrop {
+				assert.Nil(t, v)
+			} else {
+				assert.WithinDuration(t, time.Now(), v.(*Data).Time, time.Minute)
+			}
+
+			v, ok = p.next() // GPRMC
+			require.True(t, ok)
+			assert.Nil(t, v)
+
+			v, ok = p.next()
+			require.True(t, ok)
+			if tt.wantGPS {
+				got := v.(*Data).Time.UTC()
+				assert.False(t, got.Before(gpsTime))
+				assert.WithinDuration(t, gpsTime, got, time.Second)
+			} else {
+				assert.WithinDuration(t, time.Now(), v.(*Data).Time, time.Minute)
+			}
+		})
+	}
+}
+
+func TestFixTime(t *testing.T) {
+	t.Parallel()
+
+	gpsTime := time.Date(2020, 12, 17, 12, 35, 36, 0, time.UTC)
+
+	// The GPS time is extrapolated by the time that passed since it was received.
+	f := fix{utc: gpsTime, utcUpdated: time.Now().Add(-2 * time.Second)}
+	assert.WithinDuration(t, gpsTime.Add(2*time.Second), f.time(), 100*time.Millisecond)
+
+	// Stale GPS time.
+	f = fix{utc: gpsTime, utcUpdated: time.Now().Add(-fixTimeout - time.Second)}
+	assert.True(t, f.time().IsZero())
+
+	assert.True(t, (&fix{}).time().IsZero())
+}
+
+func TestPortNoFix(t *testing.T) {
+	t.Parallel()
+
+	const pflau = "$PFLAU,3,1,2,1,0,,0,,,*4F"
+	in := strings.Join([]string{
+		"$GPRMC,123535.00,A,3235.79217,N,03514.10416,E,0.006,,171220,,,A*7E",
+		// GPS time without a position fix.
+		"$GPRMC,123536,V,,,,,,,171220,,,N*54",
+		pflau,
+		// Malformed coordinates that fail parsing.
+		"$GPRMC,123537,V,,N,,E,,,171220,,,N*5E",
+		pflau,
+	}, "\r")
+	p := newPort(io.NopCloser(strings.NewReader(in)), StationInfo{Lat: 32, Long: 35, TimePolicy: TimeGPSOnly})
+
+	_, ok := p.next()
+	require.True(t, ok)
+	lat, _, _ := p.fix.location(p.station)
+	assert.InDelta(t, 32.596, lat, 0.001)
+
+	for _, sec := range []int{36, 37} {
+		v, ok := p.next() // GPRMC
+		require.True(t, ok)
+		assert.Nil(t, v)
+		lat, long, _ := p.fix.location(p.station)
+		assert.Equal(t, 32.0, lat)
+		assert.Equal(t, 35.0, long)
+
+		v, ok = p.next()
+		require.True(t, ok)
+		gpsTime := time.Date(2020, 12, 17, 12, 35, sec, 0, time.UTC)
+		assert.WithinDuration(t, gpsTime, v.(*Status).Time, time.Second)
+	}
+}
+
+func TestFixUpdateRaw(t *testing.T) {
+	t.Parallel()
+
+	f := fix{valid: true}
+	assert.True(t, f.updateRaw("$GPGGA,123537,,N,,E,0,00,99.99,,,,,,*42"))
+	assert.False(t, f.valid)
+	assert.True(t, f.time().IsZero())
+
+	assert.False(t, f.updateRaw("$GPRMC,123537,V,,N,,E,,,171220,,,N*00")) // Bad checksum.
+	assert.False(t, f.updateRaw("$PFLAU,3,1,2,1,0,,0,,,*4F"))
+	assert.False(t, f.updateRaw("GPRMC,123537"))
+	assert.True(t, f.time().IsZero())
+}
+
+func TestPortRangeOnly(t *testing.T) {
+	t.Parallel()
+
+	in := strings.Join([]string{
+		"$PFLAA,0,1500,,-120,1,4B1234,,,,,9*26",
+		"$PFLAA,0,2300,,200,,,,,,,0*45",
+	}, "\r")
+	p := newPort(io.NopCloser(strings.NewReader(in)), StationInfo{Lat: 32, Long: 35, Alt: 100})
+
+	tests := []*Data{
+		{
+			Kind:        KindRangeOnly,
+			Source:      SourceFlarm,
+			Name:        "4B1234",
+			Address:     "4B1234",
+			AddressType: "official",
+			Lat:         32,
+			Long:        35,
+			Alt:         -20,
+			Distance:    1500,
+			RelativeAlt: -120,
+			Type:        "aircraft with jet/turboprop engine(s)",
+		},
+		{
+			Kind:        KindRangeOnly,
+			Source:      SourceFlarm,
+			Name:        "Mode-C",
+			AddressType: "unknown",
+			Lat:         32,
+			Long:        35,
+			Alt:         300,
+			Distance:    2300,
+			RelativeAlt: 200,
+			Type:        "unknown",
+		},
+	}
+
+	for _, want := range tests {
+		got, ok := p.next()
+		require.True(t, ok)
+		assert.Equal(t, want, clean(t, got))
+	}
+}
@@ -9,3 +9,44 @@ type Reader interface {
 	// Close stops reading flarm data.
 	Close() error
 }
+
+// HandlerReader is a Reader that also reads the operating status of the flarm device. All the
+// readers of this package implement it.
+type HandlerReader interface {
+	Reader
+	// RangeHandler iterates over all the values received from the flarm, and calls the
+	// corresponding handler function with each of them.
+	RangeHandler(context.Context, Handler) error
+}
+
+// Handler holds the functions that are called with the values received from the flarm. Any of
+// the functions may be nil, in which case the corresponding values are ignored.
+type Handler struct {
+	// Data is called with the received positions of other aircraft.
+	Data func(Data)
+	// Status is called with the received operating status of the flarm device.
+	Status func(Status)
+}
+
+// handle calls the corresponding handler function according to the type of the given value.
+func (h Handler) handle(v interface{}) {
+	switch v := v.(type) {
+	case *Data:
+		if v != nil && h.Data != nil {
+			h.Data(*v)
+		}
+	case *Status:
+		if v != nil && h.Status != nil {
+			h.Status(*v)
+		}
+	}
+}
+
+// rangeHandler iterates over the values of a reader with the given handler. Readers that don't
+// implement HandlerReader only report data.
+func rangeHandler(ctx context.Context, r Reader, h Handler) error {
+	if hr, ok := r.(HandlerReader); ok {
+		return hr.RangeHandler(ctx, h)
+	}
+	return r.Range(ctx, func(d Data) { h.handle(&d) })
+}
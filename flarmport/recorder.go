@@ -0,0 +1,218 @@
+package flarmport
+
+import (
+	"compress/gzip"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	recorderDateFormat    = "2006-01-02"
+	recorderFlushInterval = 10 * time.Second
+)
+
+// LineRecorder records raw lines that are read by a reader.
+type LineRecorder interface {
+	Record(line string)
+}
+
+// RecorderConfig is configuration for recording raw lines into capture files.
+type RecorderConfig struct {
+	// Dir is the directory of the capture files. If empty, recording is disabled.
+	Dir string
+	// RetentionDays is the number of days to keep capture files. Zero keeps them forever.
+	RetentionDays int
+	// MaxSizeMB is the maximal total size of the capture files, in MB. When exceeded, the oldest
+	// files are removed. Zero is unlimited.
+	MaxSizeMB int64
+}
+
+// Recorder records raw lines, each with its receive timestamp, into daily-rotated gzip compressed
+// capture files. The capture files can be replayed with OpenReplay.
+type Recorder struct {
+	cfg  RecorderConfig
+	name string
+
+	mu   sync.Mutex
+	day  string
+	f    *os.File
+	w    *gzip.Writer
+	done chan struct{}
+}
+
+// OpenRecorder returns a recorder that records into capture files with the given name prefix. It
+// returns nil if recording is disabled in the configuration.
+func OpenRecorder(cfg RecorderConfig, name string) (*Recorder, error) {
+	if cfg.Dir == "" {
+		return nil, nil
+	}
+	err := os.MkdirAll(cfg.Dir, 0755)
+	if err != nil {
+		return nil, fmt.Errorf("failed creating capture directory: %v", err)
+	}
+	r := &Recorder{
+		cfg:  cfg,
+		name: name,
+		done: make(chan struct{}),
+	}
+	go r.flushLoop()
+	return r, nil
+}
+
+// Record records a line with the current time.
+func (r *Recorder) Record(line string) {
+	if r == nil {
+		return
+	}
+	r.record(time.Now().UTC(), line)
+}
+
+func (r *Recorder) record(t time.Time, line string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	select {
+	case <-r.done:
+		return
+	default:
+	}
+	err := r.rotate(t)
+	if err != nil {
+		log.Printf("Failed rotating capture file: %v", err)
+		return
+	}
+	_, err = fmt.Fprintf(r.w, "%s %s\n", t.Format(time.RFC3339Nano), line)
+	if err != nil {
+		log.Printf("Failed writing capture file: %v", err)
+	}
+}
+
+// Close flushes and closes the current capture file.
+func (r *Recorder) Close() error {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	select {
+	case <-r.done:
+		return nil
+	default:
+		close(r.done)
+	}
+	return r.closeFile()
+}
+
+// path returns the capture file path of the given day.
+func (r *Recorder) path(day string) string {
+	return filepath.Join(r.cfg.Dir, fmt.Sprintf("%s-%s.txt.gz", r.name, day))
+}
+
+// rotate opens the capture file of the day of the given time, if it is not already open. Files of
+// the same day are appended as additional gzip members.
+func (r *Recorder) rotate(t time.Time) error {
+	day := t.Format(recorderDateFormat)
+	if r.w != nil && day == r.day {
+		return nil
+	}
+	err := r.closeFile()
+	if err != nil {
+		log.Printf("Failed closing capture file: %v", err)
+	}
+	f, err := os.OpenFile(r.path(day), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	r.f = f
+	r.w = gzip.NewWriter(f)
+	r.day = day
+	r.clean(t)
+	return nil
+}
+
+func (r *Recorder) closeFile() error {
+	if r.w == nil {
+		return nil
+	}
+	err := r.w.Close()
+	if err2 := r.f.Close(); err == nil {
+		err = err2
+	}
+	r.w, r.f = nil, nil
+	return err
+}
+
+// clean removes capture files according to the retention limits. The current capture file is
+// never removed.
+func (r *Recorder) clean(now time.Time) {
+	paths, err := filepath.Glob(filepath.Join(r.cfg.Dir, r.name+"-*.txt.gz"))
+	if err != nil {
+		log.Printf("Failed listing capture files: %v", err)
+		return
+	}
+	// File names contain the date, sort from newest to oldest.
+	sort.Sort(sort.Reverse(sort.StringSlice(paths)))
+
+	var total int64
+	for _, path := range paths {
+		if path == r.path(r.day) {
+			if info, err := os.Stat(path); err == nil {
+				total += info.Size()
+			}
+			continue
+		}
+		day := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(path), r.name+"-"), ".txt.gz")
+		t, err := time.Parse(recorderDateFormat, day)
+		if err != nil {
+			// Not a capture file.
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		total += info.Size()
+		expired := r.cfg.RetentionDays > 0 && now.Sub(t) >= time.Duration(r.cfg.RetentionDays)*24*time.Hour
+		exceeded := r.cfg.MaxSizeMB > 0 && total > r.cfg.MaxSizeMB<<20
+		if expired || exceeded {
+			log.Printf("Removing capture file %s", path)
+			err := os.Remove(path)
+			if err != nil {
+				log.Printf("Failed removing capture file: %v", err)
+			}
+		}
+	}
+}
+
+// flushLoop flushes the capture file periodically, such that recorded lines are not lost if the
+// process stops.
+func (r *Recorder) flushLoop() {
+	t := time.NewTicker(recorderFlushInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-r.done:
+			return
+		case <-t.C:
+			r.flush()
+		}
+	}
+}
+
+func (r *Recorder) flush() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.w == nil {
+		return
+	}
+	err := r.w.Flush()
+	if err != nil {
+		log.Printf("Failed flushing capture file: %v", err)
+	}
+}
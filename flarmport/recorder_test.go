@@ -0,0 +1,127 @@
+package flarmport
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecorder(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	r, err := OpenRecorder(RecorderConfig{Dir: dir}, "flarm")
+	require.NoError(t, err)
+
+	day1 := time.Date(2020, 12, 17, 23, 59, 59, 0, time.UTC)
+	day2 := day1.Add(time.Second)
+	r.record(day1, "$GPRMC,235959.00,A,3235.79217,N,03514.10416,E,0.006,,171220,,,A*7D")
+	r.record(day2, "$PFLAA,0,-1388,-330,465,2,DD8E8B,78,,44,2.8,2*6F")
+	r.record(day2.Add(time.Second), "$PFLAA,0,-1388,-330,465,2,DD8E8B,78,,44,2.8,2*6F")
+	require.NoError(t, r.Close())
+
+	// Lines are not recorded after close.
+	r.Record("closed")
+
+	assert.Equal(t,
+		"2020-12-17T23:59:59Z $GPRMC,235959.00,A,3235.79217,N,03514.10416,E,0.006,,171220,,,A*7D\n",
+		readCapture(t, filepath.Join(dir, "flarm-2020-12-17.txt.gz")))
+	assert.Equal(t,
+		"2020-12-18T00:00:00Z $PFLAA,0,-1388,-330,465,2,DD8E8B,78,,44,2.8,2*6F\n"+
+			"2020-12-18T00:00:01Z $PFLAA,0,-1388,-330,465,2,DD8E8B,78,,44,2.8,2*6F\n",
+		readCapture(t, filepath.Join(dir, "flarm-2020-12-18.txt.gz")))
+
+	// Appending to the capture of the same day.
+	r, err = OpenRecorder(RecorderConfig{Dir: dir}, "flarm")
+	require.NoError(t, err)
+	r.record(day2.Add(2*time.Second), "$PFLAU,2,1,1,1,0,,0,,*61")
+	require.NoError(t, r.Close())
+	assert.Equal(t, 3, strings.Count(readCapture(t, filepath.Join(dir, "flarm-2020-12-18.txt.gz")), "\n"))
+
+	// Recorded captures can be replayed.
+	replay, err := OpenReplay(filepath.Join(dir, "flarm-2020-12-18.txt.gz"), StationInfo{TimePolicy: TimeWall}, OGNConfig{}, ReplayConfig{Speed: 100})
+	require.NoError(t, err)
+	defer replay.Close()
+	var got []interface{}
+	err = rangeHandler(context.Background(), replay.Reader, Handler{
+		Data:   func(d Data) { got = append(got, d) },
+		Status: func(s Status) { got = append(got, s) },
+	})
+	require.NoError(t, err)
+	require.Len(t, got, 3)
+	assert.Equal(t, "DD8E8B", got[0].(Data).Address)
+	assert.IsType(t, Status{}, got[2])
+}
+
+func TestRecorderPort(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	r, err := OpenRecorder(RecorderConfig{Dir: dir}, "flarm")
+	require.NoError(t, err)
+
+	in := "$PFLAU,2,1,1,1,0,,0,,*61\rgarbage\r"
+	p := newPort(io.NopCloser(strings.NewReader(in)), StationInfo{})
+	p.RecordTo(r)
+	for _, ok := p.next(); ok; _, ok = p.next() {
+	}
+	require.NoError(t, r.Close())
+
+	paths, err := filepath.Glob(filepath.Join(dir, "flarm-*.txt.gz"))
+	require.NoError(t, err)
+	require.Len(t, paths, 1)
+	lines := strings.Split(strings.TrimSpace(readCapture(t, paths[0])), "\n")
+	require.Len(t, lines, 2)
+	assert.True(t, strings.HasSuffix(lines[0], " $PFLAU,2,1,1,1,0,,0,,*61"))
+	assert.True(t, strings.HasSuffix(lines[1], " garbage"))
+}
+
+func TestRecorderRetention(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	for _, name := range []string{"flarm-2020-12-10.txt.gz", "flarm-2020-12-15.txt.gz", "flarm-2020-12-16.txt.gz", "ogn-2020-12-10.txt.gz"} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), make([]byte, 600<<10), 0644))
+	}
+
+	r, err := OpenRecorder(RecorderConfig{Dir: dir, RetentionDays: 5, MaxSizeMB: 1}, "flarm")
+	require.NoError(t, err)
+	r.record(time.Date(2020, 12, 17, 12, 0, 0, 0, time.UTC), "line")
+	require.NoError(t, r.Close())
+
+	// 2020-12-10 is expired, 2020-12-15 exceeds the size limit. Captures of other names are kept.
+	var got []string
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	for _, e := range entries {
+		got = append(got, e.Name())
+	}
+	assert.Equal(t, []string{"flarm-2020-12-16.txt.gz", "flarm-2020-12-17.txt.gz", "ogn-2020-12-10.txt.gz"}, got)
+}
+
+func TestRecorderDisabled(t *testing.T) {
+	t.Parallel()
+
+	r, err := OpenRecorder(RecorderConfig{}, "flarm")
+	require.NoError(t, err)
+	assert.Nil(t, r)
+	// Disabled recorder can be used.
+	r.Record("line")
+	assert.NoError(t, r.Close())
+}
+
+func readCapture(t *testing.T, path string) string {
+	f, err := openCapture(path)
+	require.NoError(t, err)
+	defer f.Close()
+	b, err := io.ReadAll(f)
+	require.NoError(t, err)
+	return string(b)
+}
@@ -3,12 +3,17 @@ package flarmport
 import (
 	"context"
 	"fmt"
+	"log"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/gorilla/websocket"
 )
 
 // Remote connects to a remote flarm server, and returns a an object that implements flarmReader..
+// The status of the remote flarm device is read from the "/status" path under the given address,
+// if the remote server serves it.
 func Remote(addr string) (*Conn, error) {
 	d := websocket.Dialer{
 		HandshakeTimeout: time.Second * 10,
@@ -17,24 +22,61 @@ func Remote(addr string) (*Conn, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed dialing %s: %v", addr, err)
 	}
+	c := &Conn{conn: conn}
 
-	return &Conn{conn: conn}, nil
+	statusAddr := strings.TrimSuffix(addr, "/") + "/status"
+	c.status, _, err = d.Dial(statusAddr, nil)
+	if err != nil {
+		log.Printf("Not reading status from %s: %v", statusAddr, err)
+	}
+	return c, nil
 }
 
 type Conn struct {
 	conn *websocket.Conn
+	// status is the connection to the status of the remote server, or nil if it is not served.
+	status *websocket.Conn
 }
 
+// Range iterates over the positions of other aircraft. See RangeHandler for reading all values.
 func (c *Conn) Range(ctx context.Context, f func(Data)) error {
-	defer c.conn.Close()
+	return c.RangeHandler(ctx, Handler{Data: f})
+}
+
+func (c *Conn) RangeHandler(ctx context.Context, h Handler) error {
+	// Values of both connections are handled one at a time.
+	var mu sync.Mutex
+	handle := func(v interface{}) {
+		mu.Lock()
+		defer mu.Unlock()
+		if ctx.Err() == nil {
+			h.handle(v)
+		}
+	}
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	defer c.Close()
+	if c.status != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				s, err := c.nextStatus()
+				if err != nil {
+					return
+				}
+				handle(&s)
+			}
+		}()
+	}
+
 	for ctx.Err() == nil {
 		v, err := c.next()
 		if err != nil {
 			return err
 		}
-		if ctx.Err() == nil {
-			f(v)
-		}
+		handle(&v)
 	}
 	return ctx.Err()
 }
@@ -46,6 +88,16 @@ func (c *Conn) next() (Data, error) {
 	return o, err
 }
 
+// nextStatus reads the next status from the status connection.
+func (c *Conn) nextStatus() (Status, error) {
+	var s Status
+	err := c.status.ReadJSON(&s)
+	return s, err
+}
+
 func (c *Conn) Close() error {
+	if c.status != nil {
+		c.status.Close()
+	}
 	return c.conn.Close()
 }
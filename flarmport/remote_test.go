@@ -1,9 +1,12 @@
 package flarmport
 
 import (
+	"context"
+	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/posener/wsbeam"
 	"github.com/stretchr/testify/assert"
@@ -34,3 +37,47 @@ func TestRemote(t *testing.T) {
 	_, err = f.next()
 	assert.Error(t, err)
 }
+
+func TestRemoteStatus(t *testing.T) {
+	t.Parallel()
+
+	data, status := wsbeam.New(), wsbeam.New()
+	mux := http.NewServeMux()
+	mux.Handle("/ws", data)
+	mux.Handle("/ws/status", status)
+	s := httptest.NewServer(mux)
+	defer s.Close()
+
+	f, err := Remote(strings.Replace(s.URL, "http://", "ws://", 1) + "/ws")
+	require.NoError(t, err)
+
+	gotData := make(chan Data, 10)
+	gotStatus := make(chan Status, 10)
+	go f.RangeHandler(context.Background(), Handler{
+		Data:   func(d Data) { gotData <- d },
+		Status: func(s Status) { gotStatus <- s },
+	})
+	defer f.Close()
+
+	// Send until received, since the clients might not be registered yet.
+	tick := time.NewTicker(10 * time.Millisecond)
+	defer tick.Stop()
+	var d *Data
+	var st *Status
+	for d == nil || st == nil {
+		select {
+		case <-tick.C:
+			data.Send(Data{Name: "1"})
+			status.Send(Status{Rx: 3, Intruder: &Intruder{Name: "APL"}})
+		case v := <-gotData:
+			d = &v
+		case v := <-gotStatus:
+			st = &v
+		case <-time.After(5 * time.Second):
+			t.Fatal("Timeout waiting for values")
+		}
+	}
+	assert.Equal(t, "1", d.Name)
+	assert.Equal(t, 3, st.Rx)
+	assert.Equal(t, "APL", st.Intruder.Name)
+}
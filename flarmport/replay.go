@@ -0,0 +1,210 @@
+package flarmport
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ReplayConfig is configuration for replaying a recorded capture.
+type ReplayConfig struct {
+	// Speed multiplier of the replay. 1 replays with the original timing, 2 replays twice as fast.
+	// Default is 1.
+	Speed float64
+	// Loop replays the capture again when it ends.
+	Loop bool
+}
+
+// Replay reads a recorded capture of NMEA sentences or ogn-decode lines from a file, and replays
+// it with the original timing, according to the receive time of lines that were recorded by a
+// Recorder, or otherwise the GPS time in the lines.
+type Replay struct {
+	Reader
+	// ogn is whether the capture contains ogn-decode lines.
+	ogn  bool
+	done chan struct{}
+	once sync.Once
+}
+
+// OpenReplay opens a recorded capture file for replay. The format of the capture, NMEA or
+// ogn-decode, is detected from its content.
+func OpenReplay(path string, station StationInfo, ognCfg OGNConfig, cfg ReplayConfig) (*Replay, error) {
+	ogn, err := isOGNCapture(path)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Speed <= 0 {
+		cfg.Speed = 1
+	}
+
+	pr, pw := io.Pipe()
+	r := &Replay{ogn: ogn, done: make(chan struct{})}
+	if ogn {
+		r.Reader = newOGN(pr, station, ognCfg)
+	} else {
+		r.Reader = newPort(pr, station)
+	}
+
+	go func() {
+		err := r.replay(path, pw, cfg)
+		if err != nil {
+			log.Printf("Failed replaying %s: %v", path, err)
+		}
+		pw.CloseWithError(err)
+	}()
+	return r, nil
+}
+
+// Range iterates over the positions of other aircraft. See RangeHandler for reading all values.
+func (r *Replay) Range(ctx context.Context, f func(Data)) error {
+	return r.RangeHandler(ctx, Handler{Data: f})
+}
+
+// RangeHandler replays the capture. When the capture ends, it waits until the context is
+// cancelled or the replay is closed, such that the capture is not replayed again if it is not
+// configured to loop.
+func (r *Replay) RangeHandler(ctx context.Context, h Handler) error {
+	err := rangeHandler(ctx, r.Reader, h)
+	if err != nil {
+		return err
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-r.done:
+		return nil
+	}
+}
+
+// Close stops the replay.
+func (r *Replay) Close() error {
+	r.once.Do(func() { close(r.done) })
+	return r.Reader.Close()
+}
+
+// replay writes the lines of the capture to w, with the original timing.
+func (r *Replay) replay(path string, w io.Writer, cfg ReplayConfig) error {
+	for {
+		err := r.replayOnce(path, w, cfg)
+		if err != nil || !cfg.Loop {
+			return err
+		}
+		select {
+		case <-r.done:
+			return nil
+		default:
+		}
+	}
+}
+
+func (r *Replay) replayOnce(path string, w io.Writer, cfg ReplayConfig) error {
+	f, err := openCapture(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var last time.Time
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		t, line := lineTime(s.Text())
+		if !t.IsZero() {
+			if delay := t.Sub(last); !last.IsZero() && delay > 0 {
+				select {
+				case <-r.done:
+					return nil
+				case <-time.After(time.Duration(float64(delay) / cfg.Speed)):
+				}
+			}
+			last = t
+		}
+		// The NMEA scanner splits lines on CR.
+		end := "\r"
+		if r.ogn {
+			end = "\n"
+		}
+		_, err := io.WriteString(w, line+end)
+		if err != nil {
+			return nil // Reader was closed.
+		}
+	}
+	return s.Err()
+}
+
+// lineTime returns the time of a captured line, and the line itself. Lines that were recorded by a
+// Recorder are prefixed by their receive time. Otherwise, the time of day is taken from the GPS
+// time in the line, if it has one. It returns zero time if the line has no time.
+func lineTime(line string) (time.Time, string) {
+	if i := strings.IndexByte(line, ' '); i > 0 {
+		if t, err := time.Parse(time.RFC3339Nano, line[:i]); err == nil {
+			return t, line[i+1:]
+		}
+	}
+	var hhmmss string
+	if strings.HasPrefix(line, "$") {
+		fields := strings.Split(line, ",")
+		if len(fields) < 2 || !strings.HasSuffix(fields[0], "RMC") && !strings.HasSuffix(fields[0], "GGA") {
+			return time.Time{}, line
+		}
+		hhmmss = fields[1]
+	} else if m := pattern.FindStringSubmatch(line); len(m) > 6 {
+		hhmmss = m[6]
+	}
+	if len(hhmmss) < 6 {
+		return time.Time{}, line
+	}
+	t, err := time.Parse("150405", hhmmss[:6])
+	if err != nil {
+		return time.Time{}, line
+	}
+	return t, line
+}
+
+// openCapture opens a capture file. Files with .gz extension are decompressed.
+func openCapture(path string) (io.ReadCloser, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasSuffix(path, ".gz") {
+		return f, nil
+	}
+	z, err := gzip.NewReader(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{Reader: z, Closer: f}, nil
+}
+
+// isOGNCapture returns whether the capture in the given path contains ogn-decode lines, by
+// checking the first line that is either NMEA or ogn-decode.
+func isOGNCapture(path string) (bool, error) {
+	f, err := openCapture(path)
+	if err != nil {
+		return false, fmt.Errorf("failed opening capture: %v", err)
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		_, line := lineTime(s.Text())
+		switch {
+		case strings.HasPrefix(line, "$"):
+			return false, nil
+		case pattern.MatchString(line):
+			return true, nil
+		}
+	}
+	return false, s.Err()
+}
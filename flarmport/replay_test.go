@@ -0,0 +1,129 @@
+package flarmport
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReplay(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		path   string
+		source string
+		want   int
+	}{
+		{path: "../testdata/flram.txt", source: SourceFlarm, want: 188},
+		{path: "../testdata/ogn.txt", source: SourceOGN, want: 50},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.path, func(t *testing.T) {
+			t.Parallel()
+
+			r, err := OpenReplay(tt.path, StationInfo{TimePolicy: TimeWall}, OGNConfig{}, ReplayConfig{Speed: 10000})
+			require.NoError(t, err)
+			defer r.Close()
+
+			var got []Data
+			err = r.Reader.Range(context.Background(), func(d Data) { got = append(got, d) })
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, len(got))
+			for _, d := range got {
+				assert.Equal(t, tt.source, d.Source)
+			}
+		})
+	}
+}
+
+func TestReplayTiming(t *testing.T) {
+	t.Parallel()
+
+	path := writeCapture(t,
+		"$GPRMC,123536.00,A,3235.79217,N,03514.10416,E,0.006,,171220,,,A*7D",
+		"$PFLAA,0,-1388,-330,465,2,DD8E8B,78,,44,2.8,2*6F",
+		"$GPRMC,123537.00,A,3235.79217,N,03514.10416,E,0.006,,171220,,,A*7C",
+		"$PFLAA,0,-1388,-330,465,2,DD8E8B,78,,44,2.8,2*6F",
+	)
+
+	r, err := OpenReplay(path, StationInfo{TimePolicy: TimeWall}, OGNConfig{}, ReplayConfig{Speed: 10})
+	require.NoError(t, err)
+	defer r.Close()
+
+	var got []time.Time
+	err = r.Reader.Range(context.Background(), func(d Data) { got = append(got, time.Now()) })
+	require.NoError(t, err)
+	require.Len(t, got, 2)
+
+	// One second of the capture is replayed in 100ms.
+	delay := got[1].Sub(got[0])
+	assert.True(t, delay >= 90*time.Millisecond, "delay %v", delay)
+	assert.True(t, delay < 500*time.Millisecond, "delay %v", delay)
+}
+
+func TestReplayLoop(t *testing.T) {
+	t.Parallel()
+
+	path := writeCapture(t, "$PFLAA,0,-1388,-330,465,2,DD8E8B,78,,44,2.8,2*6F")
+
+	r, err := OpenReplay(path, StationInfo{TimePolicy: TimeWall}, OGNConfig{}, ReplayConfig{Loop: true})
+	require.NoError(t, err)
+
+	count := 0
+	done := make(chan error)
+	go func() {
+		done <- r.RangeHandler(context.Background(), Handler{Data: func(d Data) {
+			count++
+			if count == 3 {
+				r.Close()
+			}
+		}})
+	}()
+
+	select {
+	case err := <-done:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Replay did not loop")
+	}
+	assert.True(t, count >= 3)
+}
+
+func TestReplayEnd(t *testing.T) {
+	t.Parallel()
+
+	path := writeCapture(t, "$PFLAA,0,-1388,-330,465,2,DD8E8B,78,,44,2.8,2*6F")
+
+	r, err := OpenReplay(path, StationInfo{TimePolicy: TimeWall}, OGNConfig{}, ReplayConfig{})
+	require.NoError(t, err)
+
+	got := make(chan Data, 1)
+	done := make(chan error)
+	go func() {
+		done <- r.Range(context.Background(), func(d Data) { got <- d })
+	}()
+	<-got
+
+	// The replay waits after the capture ends, until it is closed.
+	select {
+	case <-done:
+		t.Fatal("Replay returned before it was closed")
+	case <-time.After(100 * time.Millisecond):
+	}
+	r.Close()
+	require.NoError(t, <-done)
+}
+
+func writeCapture(t *testing.T, lines ...string) string {
+	path := filepath.Join(t.TempDir(), "capture.txt")
+	require.NoError(t, os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0644))
+	return path
+}
@@ -0,0 +1,315 @@
+package flarmport
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sbsTimeout is the time after which the state of an aircraft that was not updated is discarded.
+const sbsTimeout = time.Minute
+
+// sbsMaxClockSkew is the maximal difference between the time of a message and the wall clock.
+// Messages with a larger difference, such as when the decoder is in another time zone, are
+// considered to have no time.
+const sbsMaxClockSkew = 10 * time.Minute
+
+// SBS is a connection to a BaseStation (SBS-1) server, such as the port 30003 of dump1090, that
+// reads ADS-B aircraft. Format description at: http://woodair.net/sbs/article/barebones42_socket_data.htm.
+type SBS struct {
+	scanner *bufio.Scanner
+	io.Closer
+	station StationInfo
+	// aircraft holds the state of each of the aircraft, by ICAO address. Messages of different
+	// types update different fields of the state.
+	aircraft map[string]*sbsState
+	pruned   time.Time
+}
+
+// sbsState is the assembled state of an aircraft.
+type sbsState struct {
+	callsign string
+	// Pressure altitude in feet.
+	alt         float64
+	hasAlt      bool
+	lat, long   float64
+	hasPosition bool
+	// Ground speed in knots.
+	speed float64
+	track float64
+	// Vertical rate in feet per minute.
+	climb   float64
+	updated time.Time
+	// time is the generated time of the last message, or zero time if it is unknown.
+	time time.Time
+}
+
+// OpenSBS connects to a BaseStation server in the given address.
+func OpenSBS(addr string, station StationInfo) (*SBS, error) {
+	conn, err := net.DialTimeout("tcp", addr, time.Second*10)
+	if err != nil {
+		return nil, fmt.Errorf("failed connecting to sbs: %v", err)
+	}
+	return newSBS(conn, station), nil
+}
+
+func newSBS(conn io.ReadCloser, station StationInfo) *SBS {
+	if station.TimeZone == nil {
+		station.TimeZone = defaultTimezone
+	}
+	if station.QNH == 0 {
+		station.QNH = StandardQNH
+	}
+	return &SBS{
+		scanner:  bufio.NewScanner(conn),
+		Closer:   conn,
+		station:  station,
+		aircraft: map[string]*sbsState{},
+	}
+}
+
+// Range iterates over the positions of other aircraft. See RangeHandler for reading all values.
+func (s *SBS) Range(ctx context.Context, f func(Data)) error {
+	return s.RangeHandler(ctx, Handler{Data: f})
+}
+
+// RangeHandler iterates and parses data from the connection. It exists when the connection is
+// closed.
+func (s *SBS) RangeHandler(ctx context.Context, h Handler) error {
+	for ctx.Err() == nil {
+		value, ok := s.next()
+		if !ok {
+			return nil
+		}
+		if ctx.Err() == nil {
+			h.handle(value)
+		}
+	}
+	return ctx.Err()
+}
+
+// next used by Range and exist for testing purposes. It returns data when a position or velocity
+// message is received for an aircraft with a known position.
+func (s *SBS) next() (*Data, bool) {
+	if !s.scanner.Scan() {
+		// Stop scanning.
+		return nil, false
+	}
+	fields := strings.Split(strings.TrimSpace(s.scanner.Text()), ",")
+	if len(fields) < 17 || fields[0] != "MSG" || fields[4] == "" {
+		return nil, true
+	}
+
+	now := time.Now()
+	s.prune(now)
+
+	address := strings.ToUpper(fields[4])
+	a := s.aircraft[address]
+	if a == nil {
+		a = &sbsState{}
+		s.aircraft[address] = a
+	}
+	a.updated = now
+	a.time = sbsTime(fields[6], fields[7], now)
+
+	switch fields[1] {
+	case "1": // Identification.
+		if callsign := strings.TrimSpace(fields[10]); callsign != "" {
+			a.callsign = callsign
+		}
+		return nil, true
+	case "3": // Airborne position.
+		if v, err := strconv.ParseFloat(fields[11], 64); err == nil {
+			a.alt, a.hasAlt = v, true
+		}
+		lat, errLat := strconv.ParseFloat(fields[14], 64)
+		long, errLong := strconv.ParseFloat(fields[15], 64)
+		if errLat == nil && errLong == nil {
+			a.lat, a.long, a.hasPosition = lat, long, true
+		}
+	case "4": // Airborne velocity.
+		a.speed, _ = strconv.ParseFloat(fields[12], 64)
+		a.track, _ = strconv.ParseFloat(fields[13], 64)
+		a.climb, _ = strconv.ParseFloat(fields[16], 64)
+	default:
+		return nil, true
+	}
+	if !a.hasPosition {
+		return nil, true
+	}
+	return s.data(address, a), true
+}
+
+// data returns the data of an aircraft state.
+func (s *SBS) data(address string, a *sbsState) *Data {
+	// The message time is used as the GPS time of the time policy.
+	t, ok := s.station.timestamp(a.time)
+	if !ok {
+		return nil
+	}
+	d := &Data{
+		Kind:        KindPosition,
+		Source:      SourceADSB,
+		Lat:         a.lat,
+		Long:        a.long,
+		Dir:         int(math.Round(a.track)),
+		GroundSpeed: int64(math.Round(a.speed * knotsToMS)),
+		Climb:       math.Round(a.climb*fpmToMS*10) / 10,
+		Type:        aircraftType("0"),
+		Time:        t,
+	}
+	// BaseStation reports pressure altitude.
+	if a.hasAlt {
+		s.station.setPressureAlt(d, a.alt*feetToMeters)
+		d.Alt = *d.QNHAlt
+	}
+	s.station.identify(d, address, "official")
+	if _, ok := s.station.IDMap[address]; !ok && a.callsign != "" {
+		d.Name = a.callsign
+	}
+	return d
+}
+
+// sbsTime returns the time of a message from its date and time fields, which are in the local time
+// of the decoder. It returns zero time if the time is invalid or too far from now.
+func sbsTime(date, tod string, now time.Time) time.Time {
+	t, err := time.ParseInLocation("2006/01/02 15:04:05.999", date+" "+tod, time.Local)
+	if err != nil {
+		return time.Time{}
+	}
+	if d := t.Sub(now); d > sbsMaxClockSkew || d < -sbsMaxClockSkew {
+		return time.Time{}
+	}
+	return t
+}
+
+// prune removes aircraft that were not updated recently.
+func (s *SBS) prune(now time.Time) {
+	if now.Sub(s.pruned) < sbsTimeout {
+		return
+	}
+	s.pruned = now
+	for address, a := range s.aircraft {
+		if now.Sub(a.updated) > sbsTimeout {
+			delete(s.aircraft, address)
+		}
+	}
+}
+
+const defaultSBSQueueSize = 256
+
+// SBSServerConfig is configuration for serving data in the BaseStation format.
+type SBSServerConfig struct {
+	// Addr is the TCP address to serve BaseStation clients on, e.g. ":30003". If empty, serving is
+	// disabled.
+	Addr string
+	// QueueSize is the number of lines that are queued for each client. Default is 256.
+	QueueSize int
+}
+
+// SBSServer serves data as BaseStation MSG lines to TCP clients, such as Virtual Radar Server.
+type SBSServer struct {
+	*lineServer
+	station StationInfo
+}
+
+// NewSBSServer starts serving data in the BaseStation format. It returns nil if serving is
+// disabled in the configuration.
+func NewSBSServer(cfg SBSServerConfig, station StationInfo) (*SBSServer, error) {
+	if cfg.Addr == "" {
+		return nil, nil
+	}
+	if cfg.QueueSize == 0 {
+		cfg.QueueSize = defaultSBSQueueSize
+	}
+	s := &SBSServer{
+		lineServer: newLineServer("SBS", cfg.QueueSize),
+		station:    station,
+	}
+	err := s.listen(cfg.Addr)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Data serves identification, position and velocity messages of the given data. Range-only
+// targets are not served, since they have no position.
+func (s *SBSServer) Data(d Data) {
+	if s == nil || d.Kind == KindRangeOnly || d.Address == "" {
+		return
+	}
+	for _, line := range formatSBS(d, s.station) {
+		s.send(line)
+	}
+}
+
+// Close stops serving and disconnects all clients.
+func (s *SBSServer) Close() error {
+	if s == nil {
+		return nil
+	}
+	return s.close()
+}
+
+// formatSBS returns the BaseStation identification, position and velocity messages of the given
+// data.
+func formatSBS(d Data, station StationInfo) []string {
+	date, tm := d.Time.Format("2006/01/02"), d.Time.Format("15:04:05.000")
+	msg := func(typ string, fields map[int]string) string {
+		f := []string{"MSG", typ, "1", "1", d.Address, "1", date, tm, date, tm,
+			"", "", "", "", "", "", "", "", "", "", "", ""}
+		for i, v := range fields {
+			f[i] = v
+		}
+		return strings.Join(f, ",")
+	}
+
+	alt := d.Alt
+	if d.PressureAlt != nil {
+		alt = *d.PressureAlt
+	}
+	onGround := "0"
+	if d.GroundSpeed == 0 {
+		onGround = "-1"
+	}
+	return []string{
+		msg("1", map[int]string{10: sbsCallsign(d, station)}),
+		msg("3", map[int]string{
+			11: strconv.Itoa(int(math.Round(alt / feetToMeters))),
+			14: strconv.FormatFloat(d.Lat, 'f', 5, 64),
+			15: strconv.FormatFloat(d.Long, 'f', 5, 64),
+			18: "0", 19: "0", 20: "0", 21: onGround,
+		}),
+		msg("4", map[int]string{
+			12: strconv.Itoa(int(math.Round(float64(d.GroundSpeed) / knotsToMS))),
+			13: strconv.Itoa(d.Dir),
+			16: strconv.Itoa(int(math.Round(d.Climb / fpmToMS))),
+			21: onGround,
+		}),
+	}
+}
+
+// sbsCallsign returns the callsign of the data: the registration or competition ID of the
+// aircraft from the ID map, or its name if it is known.
+func sbsCallsign(d Data, station StationInfo) string {
+	a, ok := station.IDMap[d.Address]
+	if !ok {
+		a = Aircraft{Registration: d.Registration, CompetitionID: d.CompetitionID}
+	}
+	switch {
+	case a.Registration != "":
+		return a.Registration
+	case a.CompetitionID != "":
+		return a.CompetitionID
+	case d.Name != d.Address:
+		return d.Name
+	}
+	return ""
+}
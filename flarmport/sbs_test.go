@@ -0,0 +1,178 @@
+package flarmport
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testSBS = strings.Join([]string{
+	"MSG,1,111,11111,738065,111111,2020/12/17,12:35:36.000,2020/12/17,12:35:36.000,ELY001  ,,,,,,,,,,,",
+	"MSG,4,111,11111,738065,111111,2020/12/17,12:35:36.000,2020/12/17,12:35:36.000,,,420,90,,,-1024,,,,,0",
+	"MSG,3,111,11111,738065,111111,2020/12/17,12:35:37.000,2020/12/17,12:35:37.000,,10000,,,32.5,35.2,,,0,0,0,0",
+	"MSG,8,111,11111,738065,111111,2020/12/17,12:35:37.000,2020/12/17,12:35:37.000,,,,,,,,,,,,0",
+	"MSG,4,111,11111,4x0001,111111,2020/12/17,12:35:37.000,2020/12/17,12:35:37.000,,,100,180,,,0,,,,,0",
+	"MSG,3,111,11111,4x0001,111111,2020/12/17,12:35:38.000,2020/12/17,12:35:38.000,,,,,32.6,35.3,,,0,0,0,0",
+	"garbage",
+}, "\r\n")
+
+func TestSBS(t *testing.T) {
+	t.Parallel()
+
+	station := StationInfo{IDMap: map[string]Aircraft{"4X0001": {Name: "APL", Registration: "4X-APL"}}}
+	s := newSBS(io.NopCloser(strings.NewReader(testSBS)), station)
+
+	// Identification and velocity without position.
+	for i := 0; i < 2; i++ {
+		got, ok := s.next()
+		require.True(t, ok)
+		assert.Nil(t, got)
+	}
+
+	got, ok := s.next()
+	require.True(t, ok)
+	require.NotNil(t, got)
+	assert.False(t, got.Time.IsZero())
+	assert.Equal(t, SourceADSB, got.Source)
+	assert.Equal(t, "ELY001", got.Name)
+	assert.Equal(t, "738065", got.Address)
+	assert.Equal(t, "official", got.AddressType)
+	assert.Equal(t, 32.5, got.Lat)
+	assert.Equal(t, 35.2, got.Long)
+	assert.Equal(t, 3048.0, *got.PressureAlt)
+	assert.Equal(t, 100, *got.FlightLevel)
+	assert.InDelta(t, 3048, got.Alt, 1e-6)
+	assert.Equal(t, 90, got.Dir)
+	assert.Equal(t, int64(216), got.GroundSpeed)
+	assert.Equal(t, -5.2, got.Climb)
+
+	// Unsupported message type.
+	got, ok = s.next()
+	require.True(t, ok)
+	assert.Nil(t, got)
+
+	// Velocity without position.
+	got, ok = s.next()
+	require.True(t, ok)
+	assert.Nil(t, got)
+
+	// Mapped aircraft without altitude.
+	got, ok = s.next()
+	require.True(t, ok)
+	require.NotNil(t, got)
+	assert.Equal(t, "APL", got.Name)
+	assert.Equal(t, "4X-APL", got.Registration)
+	assert.Equal(t, "4X0001", got.Address)
+	assert.Nil(t, got.PressureAlt)
+	assert.Equal(t, 180, got.Dir)
+	assert.Equal(t, int64(51), got.GroundSpeed)
+
+	got, ok = s.next()
+	require.True(t, ok)
+	assert.Nil(t, got)
+
+	_, ok = s.next()
+	assert.False(t, ok)
+}
+
+func TestSBSTime(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now().Local().Truncate(time.Millisecond)
+	date, tod := now.Format("2006/01/02"), now.Format("15:04:05.000")
+	lines := strings.Join([]string{
+		"MSG,3,111,11111,738065,111111," + date + "," + tod + "," + date + "," + tod + ",,10000,,,32.5,35.2,,,0,0,0,0",
+		// Invalid time.
+		"MSG,3,111,11111,738065,111111,,,,,,10000,,,32.5,35.2,,,0,0,0,0",
+		// Far from the wall clock.
+		"MSG,3,111,11111,738065,111111,2020/12/17,12:35:37.000,2020/12/17,12:35:37.000,,10000,,,32.5,35.2,,,0,0,0,0",
+	}, "\r\n")
+	s := newSBS(io.NopCloser(strings.NewReader(lines)), StationInfo{TimePolicy: TimeGPSOnly})
+
+	got, ok := s.next()
+	require.True(t, ok)
+	require.NotNil(t, got)
+	assert.True(t, now.Equal(got.Time))
+
+	for i := 0; i < 2; i++ {
+		got, ok = s.next()
+		require.True(t, ok)
+		assert.Nil(t, got)
+	}
+}
+
+func TestFormatSBS(t *testing.T) {
+	t.Parallel()
+
+	station := StationInfo{IDMap: map[string]Aircraft{"DD8E8B": {Name: "APL", Registration: "4X-APL"}}}
+	pressureAlt := 1000.0
+	d := Data{
+		Kind:        KindPosition,
+		Name:        "APL",
+		Address:     "DD8E8B",
+		Lat:         32.5,
+		Long:        35.2,
+		Alt:         1100,
+		PressureAlt: &pressureAlt,
+		Dir:         90,
+		GroundSpeed: 30,
+		Climb:       -1.5,
+		Time:        time.Date(2020, 12, 17, 12, 35, 36, 0, time.UTC),
+	}
+	lines := formatSBS(d, station)
+	assert.Equal(t, []string{
+		"MSG,1,1,1,DD8E8B,1,2020/12/17,12:35:36.000,2020/12/17,12:35:36.000,4X-APL,,,,,,,,,,,",
+		"MSG,3,1,1,DD8E8B,1,2020/12/17,12:35:36.000,2020/12/17,12:35:36.000,,3281,,,32.50000,35.20000,,,0,0,0,0",
+		"MSG,4,1,1,DD8E8B,1,2020/12/17,12:35:36.000,2020/12/17,12:35:36.000,,,58,90,,,-295,,,,,0",
+	}, lines)
+
+	// The served lines can be read by the reader.
+	s := newSBS(io.NopCloser(strings.NewReader(strings.Join(lines, "\r\n"))), StationInfo{})
+	s.next()
+	s.next()
+	got, ok := s.next()
+	require.True(t, ok)
+	require.NotNil(t, got)
+	assert.Equal(t, "4X-APL", got.Name)
+	assert.InDelta(t, pressureAlt, *got.PressureAlt, 0.5)
+	assert.Equal(t, 90, got.Dir)
+	assert.Equal(t, int64(30), got.GroundSpeed)
+	assert.Equal(t, -1.5, got.Climb)
+
+	// Callsign of unknown aircraft.
+	assert.Equal(t, "", sbsCallsign(Data{Name: "AAAAAA", Address: "AAAAAA"}, station))
+	assert.Equal(t, "X1", sbsCallsign(Data{Name: "AAAAAA", Address: "AAAAAA", CompetitionID: "X1"}, station))
+}
+
+func TestSBSServer(t *testing.T) {
+	t.Parallel()
+
+	s, err := NewSBSServer(SBSServerConfig{Addr: "127.0.0.1:0"}, StationInfo{})
+	require.NoError(t, err)
+	defer s.Close()
+
+	conn, err := net.Dial("tcp", s.listener.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	require.Eventually(t, func() bool {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return len(s.clients) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	s.Data(Data{Kind: KindRangeOnly, Distance: 100}) // Not served.
+	s.Data(Data{Kind: KindPosition, Address: "DD8E8B", Name: "DD8E8B"})
+
+	r := bufio.NewReader(conn)
+	for _, typ := range []string{"1", "3", "4"} {
+		line, err := r.ReadString('\n')
+		require.NoError(t, err)
+		assert.True(t, strings.HasPrefix(line, "MSG,"+typ+",1,1,DD8E8B,"), line)
+	}
+}
@@ -0,0 +1,59 @@
+package flarmport
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"sync"
+)
+
+// udpSender sends datagrams to multiple UDP destinations, such as broadcast, multicast or unicast
+// addresses.
+type udpSender struct {
+	// name of the sent protocol, used for logging.
+	name  string
+	conn  net.PacketConn
+	addrs []net.Addr
+
+	mu   sync.Mutex
+	done chan struct{}
+	once sync.Once
+}
+
+func newUDPSender(name string, addrs []string) (*udpSender, error) {
+	s := &udpSender{name: name, done: make(chan struct{})}
+	for _, addr := range addrs {
+		a, err := net.ResolveUDPAddr("udp", addr)
+		if err != nil {
+			return nil, fmt.Errorf("failed resolving %s address %s: %v", name, addr, err)
+		}
+		s.addrs = append(s.addrs, a)
+	}
+	conn, err := net.ListenPacket("udp", ":0")
+	if err != nil {
+		return nil, fmt.Errorf("failed opening %s socket: %v", name, err)
+	}
+	s.conn = conn
+	return s, nil
+}
+
+// sendTo sends a datagram to all the destinations.
+func (s *udpSender) sendTo(b []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, addr := range s.addrs {
+		_, err := s.conn.WriteTo(b, addr)
+		if err != nil {
+			select {
+			case <-s.done:
+			default:
+				log.Printf("Failed sending %s to %s: %v", s.name, addr, err)
+			}
+		}
+	}
+}
+
+func (s *udpSender) close() error {
+	s.once.Do(func() { close(s.done) })
+	return s.conn.Close()
+}
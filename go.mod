@@ -4,6 +4,7 @@ go 1.16
 
 require (
 	github.com/adrianmo/go-nmea v1.3.0
+	github.com/eclipse/paho.mqtt.golang v1.3.5
 	github.com/gorilla/websocket v1.4.2
 	github.com/jacobsa/go-serial v0.0.0-20180131005756-15cf729a72d4
 	github.com/kr/pretty v0.2.0 // indirect
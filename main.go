@@ -9,12 +9,12 @@ import (
 	"crypto/tls"
 	"encoding/json"
 	"flag"
-	"fmt"
 	"io/ioutil"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"time"
 
 	"github.com/posener/auth"
@@ -27,13 +27,26 @@ import (
 )
 
 var (
-	port     = flag.String("port", "", "Serial port path to connect to.")
-	baudRate = flag.Uint("baud_rate", 57600, "Serial port baud rate.")
+	port     = flag.String("port", "", "Serial port path to connect to, or 'auto' to discover the port and its baud rate.")
+	baudRate = flag.Uint("baud_rate", 57600, "Serial port baud rate. Ignored when the port is discovered.")
+	nmeaAddr = flag.String("nmea", "", "Network NMEA source to read from, instead of a serial port: tcp://host:port or udp://host:port.")
 
-	remote = flag.String("remote", "", "Remote flarm server to connect to.")
+	remote = flag.String("remote", "", "Comma separated remote flarm servers to connect to.")
 
 	ogn = flag.String("ogn", "", "OGN address to connect to")
 
+	aprs = flag.String("aprs", "", "APRS-IS server to connect to, e.g. aprs.glidernet.org:14580.")
+
+	sbs = flag.String("sbs", "", "ADS-B BaseStation (SBS-1) server to connect to, e.g. localhost:30003.")
+
+	gdl90Addr = flag.String("gdl90", "", "UDP address to listen for GDL90 from Stratux/SoftRF receivers, e.g. :4000.")
+
+	replay      = flag.String("replay", "", "Recorded capture file to replay.")
+	replaySpeed = flag.Float64("replay_speed", 1, "Replay speed multiplier.")
+	replayLoop  = flag.Bool("replay_loop", false, "Replay the capture in a loop.")
+
+	igcDir = flag.String("download_igc", "", "Download the flights of the flarm device in 'port' as IGC files to the given directory and exit.")
+
 	addr       = flag.String("addr", ":8082", "Address for HTTP serving.")
 	configPath = flag.String("config", "config.json", "Configuration")
 )
@@ -45,8 +58,14 @@ var cfg struct {
 		Alt  float64
 	}
 	TimeZone string
-	// FlarmMap is mapping from FLARM ID to aircraft call name.
-	FlarmMap map[string]string
+	// TimePolicy is the source of the time of received data: "gps" (default), "gps-only" or
+	// "wall".
+	TimePolicy flarmport.TimePolicy
+	// QNH in hPa, used to calculate altitudes shown by altimeters. Default is 1013.25.
+	QNH float64
+	// FlarmMap is mapping from FLARM ID to aircraft call name, or to an object with the aircraft
+	// Name, Registration and CompetitionID.
+	FlarmMap map[string]flarmport.Aircraft
 	Cesium   cesium.Config
 	SSL      struct {
 		Cert        string
@@ -57,21 +76,52 @@ var cfg struct {
 			CacheDir     string
 		}
 	}
+	// OGN is the configuration for reading from OGN.
+	OGN flarmport.OGNConfig
+	// APRS is the configuration for reading from an APRS-IS server.
+	APRS flarmport.APRSConfig
+	// Record is the configuration for recording raw lines from the flarm port and OGN into capture
+	// files, that can be replayed with the -replay flag.
+	Record flarmport.RecorderConfig
+	// NMEA is the configuration for re-serving the NMEA stream to navigation apps.
+	NMEA flarmport.MuxConfig
+	// GDL90 is the configuration for broadcasting traffic to EFB applications.
+	GDL90 flarmport.GDL90Config
+	// SBS is the configuration for serving traffic in the BaseStation format to ADS-B tools.
+	SBS flarmport.SBSServerConfig
+	// CoT is the configuration for sending traffic as Cursor-on-Target events to ATAK.
+	CoT flarmport.CoTConfig
+	// MQTT is the configuration for publishing traffic to an MQTT broker.
+	MQTT flarmport.MQTTConfig
+	// APRSUplink is the configuration for uploading the flarm receptions to the OGN network.
+	APRSUplink flarmport.APRSUplinkConfig
 	Log        logger.Config
 	Admin      admin.Config
 	GoogleAuth auth.Config
 
 	FlarmReconnectDelaySec int
+	// FusionWindowSec is the time window in which reports of the same aircraft from different
+	// sources are considered duplicates. Default is 5s.
+	FusionWindowSec int
+	// Discovery is the configuration for discovering the flarm serial port, when the port flag is
+	// 'auto'.
+	Discovery flarmport.DiscoveryConfig
+	// LogBadLinesSec is the interval in which a sample of each class of bad lines that are read
+	// from the flarm and OGN sources is logged. Zero disables logging.
+	LogBadLinesSec int
 }
 
-const defaultFlarmReconnectDelay = time.Second * 3
-
 func main() {
 	flag.Parse()
 	log.SetFlags(log.Lshortfile | log.LstdFlags)
 	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
 	defer cancel()
 
+	if *igcDir != "" {
+		downloadIGC(ctx)
+		return
+	}
+
 	for ctx.Err() == nil {
 		serve(ctx)
 	}
@@ -102,12 +152,20 @@ func serve(ctx context.Context) {
 	}
 
 	conns := wsbeam.New()
+	statusConns := wsbeam.New()
 	cesium, err := cesium.New(cfg.Cesium)
 	if err != nil {
 		log.Fatalf("Failed loading cesium server: %s", err)
 	}
 
-	adminHandler, err := admin.New(cfg.Admin, *configPath, cfg, cancel)
+	// The flarm device status is served and it can be configured from the admin page when reading
+	// from a serial port.
+	device := &flarmport.Device{}
+	var adminDevice admin.Device
+	if *port != "" {
+		adminDevice = device
+	}
+	adminHandler, err := admin.New(cfg.Admin, *configPath, cfg, cancel, adminDevice)
 	if err != nil {
 		log.Fatalf("Failed loading admin handler: %s", err)
 	}
@@ -117,8 +175,36 @@ func serve(ctx context.Context) {
 		log.Fatalf("Failed loading auth middleware: %s", err)
 	}
 
+	// Statistics of the lines that are read from the flarm and OGN sources.
+	logBadLines := time.Duration(cfg.LogBadLinesSec) * time.Second
+	lineStats := map[string]*flarmport.LineStats{
+		flarmport.SourceFlarm: {Name: flarmport.SourceFlarm, LogInterval: logBadLines},
+		flarmport.SourceOGN:   {Name: flarmport.SourceOGN, LogInterval: logBadLines},
+	}
+
 	mux := http.NewServeMux()
 	mux.Handle("/ws", conns)
+	mux.Handle("/ws/status", statusConns)
+	deviceHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		status, err := device.DeviceStatus()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(status)
+	})
+	// The device status is protected like the admin page that shows it.
+	mux.Handle("/device", authHandler.Authenticate(adminHandler.Authorize(deviceHandler)))
+	linesHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		counts := make(map[string]flarmport.LineCounts, len(lineStats))
+		for name, s := range lineStats {
+			counts[name] = s.Counts()
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(counts)
+	})
+	mux.Handle("/lines", authHandler.Authenticate(adminHandler.Authorize(linesHandler)))
 	mux.Handle("/", cesium)
 	mux.Handle("/admin", http.StripPrefix("/admin", authHandler.Authenticate(adminHandler)))
 	mux.Handle("/auth", authHandler.RedirectHandler())
@@ -155,38 +241,94 @@ func serve(ctx context.Context) {
 	}()
 
 	station := flarmport.StationInfo{
-		Lat:      cfg.Location.Lat,
-		Long:     cfg.Location.Long,
-		Alt:      cfg.Location.Alt,
-		IDMap:    cfg.FlarmMap,
-		TimeZone: location,
+		Lat:        cfg.Location.Lat,
+		Long:       cfg.Location.Long,
+		Alt:        cfg.Location.Alt,
+		IDMap:      cfg.FlarmMap,
+		TimeZone:   location,
+		TimePolicy: cfg.TimePolicy,
+		QNH:        cfg.QNH,
 	}
 
-	go func() {
-		for ctx.Err() == nil {
-			flarm, err := getFlarm(station)
-			if err == nil {
-				defer flarm.Close()
-				log.Println("Start reading flarm data...")
-				err = flarm.Range(ctx, func(o flarmport.Data) {
-					log.Printf("sending %+v", o)
-					sendLog.Log(o)
-					conns.Send(o)
-				})
-			}
+	flarmRecorder, err := flarmport.OpenRecorder(cfg.Record, flarmport.SourceFlarm)
+	if err != nil {
+		log.Fatalf("Failed initializing recorder: %s", err)
+	}
+	defer flarmRecorder.Close()
+	ognRecorder, err := flarmport.OpenRecorder(cfg.Record, flarmport.SourceOGN)
+	if err != nil {
+		log.Fatalf("Failed initializing recorder: %s", err)
+	}
+	defer ognRecorder.Close()
 
-			if err != nil {
-				log.Printf("Failed iterating flarm values: %v", err)
-			}
-			// If context was not cancelled, reconnect to flarm.
-			if ctx.Err() == nil {
-				flarmReconnectDelay := time.Duration(cfg.FlarmReconnectDelaySec) * time.Second
-				if flarmReconnectDelay == 0 {
-					flarmReconnectDelay = defaultFlarmReconnectDelay
-				}
-				log.Printf("Will try to reconnect to flarm in %v...", flarmReconnectDelay)
-				time.Sleep(flarmReconnectDelay)
-			}
+	nmeaMux, err := flarmport.NewMux(cfg.NMEA, station)
+	if err != nil {
+		log.Fatalf("Failed initializing NMEA server: %s", err)
+	}
+	defer nmeaMux.Close()
+
+	gdl90, err := flarmport.NewGDL90Broadcaster(cfg.GDL90, station)
+	if err != nil {
+		log.Fatalf("Failed initializing GDL90 broadcaster: %s", err)
+	}
+	defer gdl90.Close()
+
+	sbsServer, err := flarmport.NewSBSServer(cfg.SBS, station)
+	if err != nil {
+		log.Fatalf("Failed initializing SBS server: %s", err)
+	}
+	defer sbsServer.Close()
+
+	cot, err := flarmport.NewCoT(cfg.CoT, station)
+	if err != nil {
+		log.Fatalf("Failed initializing CoT: %s", err)
+	}
+	defer cot.Close()
+
+	mqtt, err := flarmport.NewMQTT(cfg.MQTT)
+	if err != nil {
+		log.Fatalf("Failed initializing MQTT: %s", err)
+	}
+	defer mqtt.Close()
+
+	aprsUplink, err := flarmport.NewAPRSUplink(cfg.APRSUplink, station)
+	if err != nil {
+		log.Fatalf("Failed initializing APRS uplink: %s", err)
+	}
+	defer aprsUplink.Close()
+
+	inputs := getInputs(ctx, station, device, lineStats,
+		[]flarmport.LineRecorder{flarmRecorder, nmeaMux},
+		[]flarmport.LineRecorder{ognRecorder})
+	if len(inputs) == 0 {
+		log.Fatal("Usage: must provide at least one of 'port', 'nmea', 'ogn', 'aprs', 'sbs', 'gdl90', 'remote' or 'replay'.")
+	}
+	flarm := flarmport.Fuse(flarmport.FusionConfig{
+		WindowSec:         cfg.FusionWindowSec,
+		ReconnectDelaySec: cfg.FlarmReconnectDelaySec,
+	}, inputs...)
+
+	go func() {
+		log.Println("Start reading flarm data...")
+		err := flarm.RangeHandler(ctx, flarmport.Handler{
+			Data: func(o flarmport.Data) {
+				log.Printf("sending %+v", o)
+				sendLog.Log(o)
+				conns.Send(o)
+				mqtt.Data(o)
+				nmeaMux.Data(o)
+				gdl90.Data(o)
+				sbsServer.Data(o)
+				cot.Data(o)
+				aprsUplink.Data(o)
+			},
+			Status: func(s flarmport.Status) {
+				statusConns.Send(s)
+				nmeaMux.Status(s)
+			},
+		})
+		if err != nil && ctx.Err() == nil {
+			log.Printf("Failed iterating flarm values: %v", err)
 		}
 	}()
 
@@ -209,6 +351,10 @@ func loadConfig() {
 	}
 	cfg.GoogleAuth.Log = log.Printf
 
+	if !cfg.TimePolicy.Valid() {
+		log.Fatalf("Invalid time policy %q.", cfg.TimePolicy)
+	}
+
 	// Check SSL config.
 	if ssl := cfg.SSL; ssl.Cert != "" || ssl.Key != "" {
 		if ssl.LetsEncrypt.Enabled {
@@ -225,30 +371,148 @@ func loadConfig() {
 	}
 }
 
-func getFlarm(station flarmport.StationInfo) (flarmport.Reader, error) {
-	switch {
-	case countInputSelection() > 1:
-		log.Fatal("Usage: can't use multiple sources. Must select one of 'port', 'ogn' or 'remote'.")
-	case *port != "":
-		return flarmport.Open(*port, *baudRate, station)
-	case *ogn != "":
-		return flarmport.OpenOGN(*ogn, station)
-	case *remote != "":
-		return flarmport.Remote(*remote)
-	}
-	return nil, fmt.Errorf("usage: must provide 'port' or 'remote'")
+// portAuto is the port flag value for discovering the flarm serial port.
+const portAuto = "auto"
+
+// openPort opens the flarm serial port. If the port flag is 'auto', the port is discovered, and a
+// port that was discovered before is tried first.
+func openPort(ctx context.Context, discovery *flarmport.Discovery, station flarmport.StationInfo) (*flarmport.Port, error) {
+	if *port == portAuto {
+		return discovery.Open(ctx, station)
+	}
+	return flarmport.Open(*port, *baudRate, station)
+}
+
+// newDiscovery returns a discovery of the flarm serial port.
+func newDiscovery() *flarmport.Discovery {
+	discoveryCfg := cfg.Discovery
+	// Don't probe the port that the NMEA stream is re-served to.
+	if cfg.NMEA.Port != "" {
+		discoveryCfg.Exclude = append(discoveryCfg.Exclude, cfg.NMEA.Port)
+	}
+	return flarmport.NewDiscovery(discoveryCfg)
+}
+
+// downloadIGC downloads the flights of the flarm device to the IGC directory.
+func downloadIGC(ctx context.Context) {
+	if *port == "" {
+		log.Fatalf("Downloading IGC files requires a flarm port")
+	}
+	// The configuration is needed for discovering the port.
+	loadConfig()
+	p, err := openPort(ctx, newDiscovery(), flarmport.StationInfo{})
+	if err != nil {
+		log.Fatalf("Failed opening flarm port: %s", err)
+	}
+	defer p.Close()
+
+	// The port must be read while downloading.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go p.RangeHandler(ctx, flarmport.Handler{})
+
+	paths, err := p.DownloadIGC(ctx, *igcDir)
+	if err != nil {
+		log.Fatalf("Failed downloading IGC files: %s", err)
+	}
+	log.Printf("Downloaded %d IGC files to %s", len(paths), *igcDir)
 }
 
-func countInputSelection() int {
-	s := 0
+// getInputs returns the input sources that were selected by the flags.
+func getInputs(ctx context.Context, station flarmport.StationInfo, device *flarmport.Device, lineStats map[string]*flarmport.LineStats, flarmRecorders, ognRecorders []flarmport.LineRecorder) []flarmport.Source {
+	var inputs []flarmport.Source
 	if *port != "" {
-		s++
+		discovery := newDiscovery()
+		inputs = append(inputs, flarmport.Source{
+			Name: flarmport.SourceFlarm,
+			Open: func() (flarmport.Reader, error) {
+				p, err := openPort(ctx, discovery, station)
+				if err != nil {
+					return nil, err
+				}
+				for _, r := range flarmRecorders {
+					p.RecordTo(r)
+				}
+				p.CountTo(lineStats[flarmport.SourceFlarm])
+				device.Set(p)
+				if err := p.QueryDeviceStatus(); err != nil {
+					log.Printf("Failed querying flarm device status: %s", err)
+				}
+				return p, nil
+			},
+		})
+	}
+	if *nmeaAddr != "" {
+		inputs = append(inputs, flarmport.Source{
+			Name: flarmport.SourceFlarm + " " + *nmeaAddr,
+			Open: func() (flarmport.Reader, error) {
+				p, err := flarmport.OpenNetwork(*nmeaAddr, station)
+				if err != nil {
+					return nil, err
+				}
+				for _, r := range flarmRecorders {
+					p.RecordTo(r)
+				}
+				p.CountTo(lineStats[flarmport.SourceFlarm])
+				return p, nil
+			},
+		})
 	}
 	if *ogn != "" {
-		s++
+		inputs = append(inputs, flarmport.Source{
+			Name: flarmport.SourceOGN,
+			Open: func() (flarmport.Reader, error) {
+				o, err := flarmport.OpenOGN(*ogn, station, cfg.OGN)
+				if err != nil {
+					return nil, err
+				}
+				for _, r := range ognRecorders {
+					o.RecordTo(r)
+				}
+				o.CountTo(lineStats[flarmport.SourceOGN])
+				return o, nil
+			},
+		})
 	}
-	if *remote != "" {
-		s++
+	if *aprs != "" {
+		inputs = append(inputs, flarmport.Source{
+			Name: flarmport.SourceAPRS,
+			Open: func() (flarmport.Reader, error) { return flarmport.OpenAPRS(*aprs, station, cfg.APRS) },
+		})
+	}
+	if *sbs != "" {
+		inputs = append(inputs, flarmport.Source{
+			Name: flarmport.SourceADSB,
+			Open: func() (flarmport.Reader, error) { return flarmport.OpenSBS(*sbs, station) },
+		})
+	}
+	if *gdl90Addr != "" {
+		inputs = append(inputs, flarmport.Source{
+			Name: flarmport.SourceGDL90,
+			Open: func() (flarmport.Reader, error) { return flarmport.OpenGDL90(*gdl90Addr, station) },
+		})
+	}
+	if *replay != "" {
+		// Recorded GPS times are in the past, report the replayed data in the current time.
+		replayStation := station
+		replayStation.TimePolicy = flarmport.TimeWall
+		replayCfg := flarmport.ReplayConfig{Speed: *replaySpeed, Loop: *replayLoop}
+		inputs = append(inputs, flarmport.Source{
+			Name: "replay",
+			Open: func() (flarmport.Reader, error) {
+				return flarmport.OpenReplay(*replay, replayStation, cfg.OGN, replayCfg)
+			},
+		})
+	}
+	for _, addr := range strings.Split(*remote, ",") {
+		if addr == "" {
+			continue
+		}
+		addr := addr
+		inputs = append(inputs, flarmport.Source{
+			Name: "remote " + addr,
+			Open: func() (flarmport.Reader, error) { return flarmport.Remote(addr) },
+		})
 	}
-	return s
+	return inputs
 }